@@ -1,13 +1,26 @@
 module github.com/DocHQ/helpers
 
-go 1.15
+go 1.16
 
 require (
 	github.com/DocHQ/logging v0.0.4
+	github.com/alicebob/miniredis/v2 v2.14.1
+	github.com/andybalholm/brotli v1.0.4
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.3.0
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sendgrid/rest v2.6.7+incompatible // indirect
 	github.com/sendgrid/sendgrid-go v3.10.5+incompatible
 	github.com/ugorji/go/codec v1.1.7
-	google.golang.org/grpc v1.35.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/text v0.3.6
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"math"
+	"time"
+
+	"github.com/DocHQ/helpers/internal/env"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Config holds the connection level settings used by NewRPCServer. The zero
+// value is not useful, start from DefaultConfig or ConfigFromEnv.
+type Config struct {
+	// Keepalive parameters, see ServerKeepaliveParams for what each one does
+	KeepaliveTime         time.Duration
+	KeepaliveTimeout      time.Duration
+	MaxConnectionIdle     time.Duration
+	MaxConnectionAge      time.Duration
+	MaxConnectionAgeGrace time.Duration
+
+	// Keepalive enforcement policy, clients pinging more often than
+	// KeepaliveMinTime get their connection closed with GOAWAY
+	KeepaliveMinTime             time.Duration
+	KeepalivePermitWithoutStream bool
+
+	// Message size limits in bytes
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// MaxConcurrentStreams limits the streams per client connection,
+	// 0 leaves it unlimited
+	MaxConcurrentStreams uint32
+
+	// ConnectionTimeout is how long a new connection has to complete its
+	// handshake before it is dropped
+	ConnectionTimeout time.Duration
+}
+
+// DefaultConfig returns the values NewRPCServer has always used, the keepalive
+// settings match ServerKeepaliveParams and everything else matches the gRPC
+// library defaults.
+func DefaultConfig() Config {
+	return Config{
+		KeepaliveTime:         30 * time.Second,
+		KeepaliveTimeout:      60 * time.Second,
+		MaxConnectionAge:      5 * time.Minute,
+		MaxConnectionAgeGrace: 60 * time.Second,
+
+		KeepaliveMinTime: 5 * time.Minute,
+
+		MaxRecvMsgSize: 4 * 1024 * 1024,
+		MaxSendMsgSize: math.MaxInt32,
+
+		ConnectionTimeout: 120 * time.Second,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig with any values overridden from the
+// environment (a .env file is loaded by the godotenv autoload import).
+// Durations use time.ParseDuration syntax, e.g. GRPC_KEEPALIVE_TIME=45s.
+// Invalid values are logged and the default is kept.
+func ConfigFromEnv() Config {
+	c := DefaultConfig()
+
+	c.KeepaliveTime = env.Duration("GRPC_KEEPALIVE_TIME", c.KeepaliveTime)
+	c.KeepaliveTimeout = env.Duration("GRPC_KEEPALIVE_TIMEOUT", c.KeepaliveTimeout)
+	c.MaxConnectionIdle = env.Duration("GRPC_MAX_CONNECTION_IDLE", c.MaxConnectionIdle)
+	c.MaxConnectionAge = env.Duration("GRPC_MAX_CONNECTION_AGE", c.MaxConnectionAge)
+	c.MaxConnectionAgeGrace = env.Duration("GRPC_MAX_CONNECTION_AGE_GRACE", c.MaxConnectionAgeGrace)
+
+	c.KeepaliveMinTime = env.Duration("GRPC_KEEPALIVE_MIN_TIME", c.KeepaliveMinTime)
+	c.KeepalivePermitWithoutStream = env.Bool("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM", c.KeepalivePermitWithoutStream)
+
+	c.MaxRecvMsgSize = env.Int("GRPC_MAX_RECV_MSG_SIZE", c.MaxRecvMsgSize)
+	c.MaxSendMsgSize = env.Int("GRPC_MAX_SEND_MSG_SIZE", c.MaxSendMsgSize)
+	// Capped at MaxInt32 so it fits an int everywhere and can't wrap
+	c.MaxConcurrentStreams = uint32(env.IntRange("GRPC_MAX_CONCURRENT_STREAMS", int(c.MaxConcurrentStreams), 0, math.MaxInt32))
+
+	c.ConnectionTimeout = env.Duration("GRPC_CONNECTION_TIMEOUT", c.ConnectionTimeout)
+
+	return c
+}
+
+// ServerOptions converts the config into the options passed to grpc.NewServer
+func (c Config) ServerOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  c.KeepaliveTime,
+			Timeout:               c.KeepaliveTimeout,
+			MaxConnectionIdle:     c.MaxConnectionIdle,
+			MaxConnectionAge:      c.MaxConnectionAge,
+			MaxConnectionAgeGrace: c.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             c.KeepaliveMinTime,
+			PermitWithoutStream: c.KeepalivePermitWithoutStream,
+		}),
+		grpc.MaxRecvMsgSize(c.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(c.MaxSendMsgSize),
+		grpc.ConnectionTimeout(c.ConnectionTimeout),
+	}
+
+	// 0 is unlimited in grpc too, only pass it on when a limit is set
+	if c.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(c.MaxConcurrentStreams))
+	}
+
+	return opts
+}
@@ -0,0 +1,52 @@
+package grpc
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(c Config) bool
+	}{
+		{"defaults", nil, func(c Config) bool { return c == DefaultConfig() }},
+		{"duration", map[string]string{"GRPC_KEEPALIVE_TIME": "45s"}, func(c Config) bool { return c.KeepaliveTime == 45*time.Second }},
+		{"invalid duration keeps the default", map[string]string{"GRPC_KEEPALIVE_TIME": "45"}, func(c Config) bool { return c.KeepaliveTime == 30*time.Second }},
+		{"bool", map[string]string{"GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM": "1"}, func(c Config) bool { return c.KeepalivePermitWithoutStream }},
+		{"int", map[string]string{"GRPC_MAX_RECV_MSG_SIZE": "1024"}, func(c Config) bool { return c.MaxRecvMsgSize == 1024 }},
+		{"negative int keeps the default", map[string]string{"GRPC_MAX_RECV_MSG_SIZE": "-1"}, func(c Config) bool { return c.MaxRecvMsgSize == 4*1024*1024 }},
+		{"concurrent streams", map[string]string{"GRPC_MAX_CONCURRENT_STREAMS": "100"}, func(c Config) bool { return c.MaxConcurrentStreams == 100 }},
+		{"concurrent streams past MaxInt32", map[string]string{"GRPC_MAX_CONCURRENT_STREAMS": "4294967297"}, func(c Config) bool { return c.MaxConcurrentStreams == 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+			defer func() {
+				for k := range tt.env {
+					os.Unsetenv(k)
+				}
+			}()
+
+			if c := ConfigFromEnv(); !tt.check(c) {
+				t.Errorf("ConfigFromEnv() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestConfigServerOptions(t *testing.T) {
+	c := DefaultConfig()
+	if got := len(c.ServerOptions()); got != 5 {
+		t.Errorf("got %d options, want 5 without a stream limit", got)
+	}
+	c.MaxConcurrentStreams = math.MaxInt32
+	if got := len(c.ServerOptions()); got != 6 {
+		t.Errorf("got %d options, want 6 with a stream limit", got)
+	}
+}
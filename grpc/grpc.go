@@ -24,13 +24,28 @@ import (
 
 var listener net.Listener
 
+// NewRPCServer listens on port and returns a server configured from
+// ConfigFromEnv. Any options passed in are applied after the config so they
+// take precedence. The request id, tracing and metrics interceptors are always
+// installed, add further interceptors with grpc.ChainUnaryInterceptor.
 func NewRPCServer(port string, opt ...grpc.ServerOption) (server *grpc.Server, err error) {
+	return NewRPCServerWithConfig(port, ConfigFromEnv(), opt...)
+}
+
+// NewRPCServerWithConfig is NewRPCServer with an explicit config, for services
+// that build their settings in code rather than from the environment.
+func NewRPCServerWithConfig(port string, config Config, opt ...grpc.ServerOption) (server *grpc.Server, err error) {
 	listener, err = net.Listen("tcp", port)
 	if err != nil {
 		return server, err
 	}
 
-	return grpc.NewServer(append(opt, ServerKeepaliveParams)...), nil
+	opts := append(config.ServerOptions(),
+		grpc.ChainUnaryInterceptor(RequestIDUnaryServerInterceptor, TracingUnaryServerInterceptor, MetricsUnaryServerInterceptor),
+		grpc.ChainStreamInterceptor(RequestIDStreamServerInterceptor, TracingStreamServerInterceptor, MetricsStreamServerInterceptor),
+	)
+
+	return grpc.NewServer(append(opts, opt...)...), nil
 }
 
 func StartServer(srv *grpc.Server) {
@@ -71,6 +86,8 @@ CronLoop:
 }
 
 // ServerKeepaliveParams - gRPC Server Keepalive Parameters
+// These are the defaults in DefaultConfig, NewRPCServer no longer appends this
+// directly but it is kept for services that pass it to grpc.NewServer themselves.
 var ServerKeepaliveParams = grpc.KeepaliveParams(keepalive.ServerParameters{
 	// After a duration of this time if the server doesn't see any activity it
 	// pings the client to see if the transport is still alive.
@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DocHQ/helpers/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// MetricsUnaryServerInterceptor records Prometheus metrics for each call,
+// labelled by service, method and status code:
+//
+//	grpc_server_handled_total
+//	grpc_server_handling_seconds
+//	grpc_server_in_flight
+//	grpc_server_response_size_bytes
+//
+// NewRPCServer installs this by default. The metrics go in the default
+// registry, expose them with http.WithMetrics or promhttp.Handler().
+func MetricsUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	m := registerGRPCMetrics()
+	service, method := splitMethod(info.FullMethod)
+
+	inFlight := m.inFlight.WithLabelValues(service, method)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	code := status.Code(err).String()
+
+	m.handled.WithLabelValues(service, method, code).Inc()
+	m.duration.WithLabelValues(service, method, code).Observe(time.Since(start).Seconds())
+	if msg, ok := resp.(proto.Message); ok && err == nil {
+		m.size.WithLabelValues(service, method, code).Observe(float64(proto.Size(msg)))
+	}
+
+	return resp, err
+}
+
+// MetricsStreamServerInterceptor is the streaming equivalent of
+// MetricsUnaryServerInterceptor, the duration covers the life of the stream
+// and no response size is recorded.
+func MetricsStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	m := registerGRPCMetrics()
+	service, method := splitMethod(info.FullMethod)
+
+	inFlight := m.inFlight.WithLabelValues(service, method)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	err := handler(srv, ss)
+	code := status.Code(err).String()
+
+	m.handled.WithLabelValues(service, method, code).Inc()
+	m.duration.WithLabelValues(service, method, code).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+type grpcMetrics struct {
+	handled  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	size     *prometheus.HistogramVec
+}
+
+var (
+	grpcMetricsOnce sync.Once
+	grpcMetricsSet  *grpcMetrics
+)
+
+// registerGRPCMetrics registers the collectors on first use so services that
+// never make a call don't export empty metrics
+func registerGRPCMetrics() *grpcMetrics {
+	grpcMetricsOnce.Do(func() {
+		labels := []string{"grpc_service", "grpc_method", "grpc_code"}
+		grpcMetricsSet = &grpcMetrics{
+			handled: metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "grpc_server_handled_total",
+				Help: "Number of RPCs completed on the server, by service, method and status code.",
+			}, labels)).(*prometheus.CounterVec),
+			duration: metrics.Register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "grpc_server_handling_seconds",
+				Help:    "Time taken to handle RPCs, by service, method and status code.",
+				Buckets: prometheus.DefBuckets,
+			}, labels)).(*prometheus.HistogramVec),
+			inFlight: metrics.Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "grpc_server_in_flight",
+				Help: "Number of RPCs currently being handled, by service and method.",
+			}, []string{"grpc_service", "grpc_method"})).(*prometheus.GaugeVec),
+			size: metrics.Register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "grpc_server_response_size_bytes",
+				Help:    "Size of unary RPC responses, by service, method and status code.",
+				Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+			}, labels)).(*prometheus.HistogramVec),
+		}
+	})
+	return grpcMetricsSet
+}
+
+// splitMethod turns /package.Service/Method into its service and method
+func splitMethod(fullMethod string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2)
+	if len(parts) != 2 {
+		return "unknown", fullMethod
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/DocHQ/helpers/ratelimit"
+	"github.com/DocHQ/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimitOptions configures the rate limiting interceptors
+type RateLimitOptions struct {
+	// Limiter applies to every method without an entry in Methods, nil means
+	// those methods are not limited
+	Limiter *ratelimit.Limiter
+
+	// Methods sets a limit for particular methods, keyed by full method name
+	// e.g. "/product.ProductService/Create". Each method is counted separately.
+	Methods map[string]*ratelimit.Limiter
+
+	// Key picks who the limit applies to, RateLimitByPeer if nil. Return ""
+	// to not limit a call.
+	Key func(ctx context.Context, fullMethod string) string
+
+	// FailClosed rejects calls when the store can't be reached, by default
+	// they are let through and the error is logged
+	FailClosed bool
+}
+
+// RateLimitUnaryServerInterceptor rejects callers making too many calls with
+// codes.ResourceExhausted. The ratelimit-limit, ratelimit-remaining and
+// ratelimit-reset headers are sent on every call, and retry-after on rejected
+// ones. Add it with grpc.ChainUnaryInterceptor.
+func RateLimitUnaryServerInterceptor(opts RateLimitOptions) grpc.UnaryServerInterceptor {
+	opts = opts.withDefaults()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := opts.allow(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitStreamServerInterceptor is the streaming equivalent of
+// RateLimitUnaryServerInterceptor, opening a stream counts as one call
+func RateLimitStreamServerInterceptor(opts RateLimitOptions) grpc.StreamServerInterceptor {
+	opts = opts.withDefaults()
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := opts.allow(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// RateLimitByPeer limits each client address separately
+func RateLimitByPeer(ctx context.Context, fullMethod string) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:" + host
+}
+
+// RateLimitByCaller limits each caller separately by the authorization
+// metadata, falling back to the client address for anonymous calls
+func RateLimitByCaller(ctx context.Context, fullMethod string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 && values[0] != "" {
+			// Don't keep credentials in the store
+			sum := sha256.Sum256([]byte(values[0]))
+			return "auth:" + hex.EncodeToString(sum[:16])
+		}
+	}
+	return RateLimitByPeer(ctx, fullMethod)
+}
+
+func (opts RateLimitOptions) withDefaults() RateLimitOptions {
+	if opts.Key == nil {
+		opts.Key = RateLimitByPeer
+	}
+	return opts
+}
+
+func (opts RateLimitOptions) allow(ctx context.Context, fullMethod string) error {
+	limiter, scope := opts.Limiter, ""
+	if methodLimiter, ok := opts.Methods[fullMethod]; ok {
+		limiter, scope = methodLimiter, fullMethod+":"
+	}
+
+	key := opts.Key(ctx, fullMethod)
+	if limiter == nil || key == "" {
+		return nil
+	}
+
+	result, err := limiter.Allow(ctx, scope+key)
+	if err != nil {
+		logging.Errorf("rate limit store error: %v", err)
+		if opts.FailClosed {
+			return status.Error(codes.Unavailable, "rate limit unavailable")
+		}
+		return nil
+	}
+
+	md := metadata.Pairs(
+		"ratelimit-limit", strconv.Itoa(result.Limit),
+		"ratelimit-remaining", strconv.Itoa(result.Remaining),
+		"ratelimit-reset", strconv.Itoa(ceilSeconds(result.Reset)),
+	)
+	if !result.Allowed {
+		md.Set("retry-after", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	}
+	// Failing to set the header only means the client doesn't see it
+	_ = grpc.SetHeader(ctx, md)
+
+	if !result.Allowed {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+// ceilSeconds rounds up so clients are not told to come back too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
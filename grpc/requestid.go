@@ -0,0 +1,83 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/DocHQ/helpers/requestid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDUnaryServerInterceptor takes the request id from the incoming
+// x-request-id metadata (generating one if it is missing), stores it in the
+// context for the handler and sends it back in the response header.
+// NewRPCServer installs this by default.
+func RequestIDUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = requestIDContext(ctx)
+	return handler(ctx, req)
+}
+
+// RequestIDStreamServerInterceptor is the streaming equivalent of
+// RequestIDUnaryServerInterceptor
+func RequestIDStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: requestIDContext(ss.Context())})
+}
+
+// RequestIDUnaryClientInterceptor forwards the request id in ctx (see
+// requestid.FromContext) to the server being called, pass it to grpc.Dial with
+// grpc.WithChainUnaryInterceptor
+func RequestIDUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoingRequestIDContext(ctx), method, req, reply, cc, opts...)
+}
+
+// RequestIDStreamClientInterceptor is the streaming equivalent of
+// RequestIDUnaryClientInterceptor
+func RequestIDStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoingRequestIDContext(ctx), desc, cc, method, opts...)
+}
+
+// RequestIDFromContext returns the request id for the current call
+func RequestIDFromContext(ctx context.Context) string {
+	return requestid.FromContext(ctx)
+}
+
+func requestIDContext(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestid.MetadataKey); len(values) > 0 && requestid.Valid(values[0]) {
+			id = values[0]
+		} else if values := md.Get("traceparent"); len(values) > 0 {
+			id = requestid.FromTraceparent(values[0])
+		}
+	}
+	if id == "" {
+		id = requestid.New()
+	}
+
+	// Failing to set the header only means the client doesn't get the id back
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+
+	return requestid.NewContext(ctx, id)
+}
+
+func outgoingRequestIDContext(ctx context.Context) context.Context {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestid.MetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestid.MetadataKey, id)
+}
+
+// contextServerStream lets a stream interceptor replace the stream's context
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
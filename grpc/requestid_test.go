@@ -0,0 +1,79 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DocHQ/helpers/requestid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDUnaryServerInterceptor(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		want string
+	}{
+		{"from metadata", metadata.Pairs(requestid.MetadataKey, "abc-123"), "abc-123"},
+		{"from traceparent", metadata.Pairs("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"), traceID},
+		{"invalid metadata", metadata.Pairs(requestid.MetadataKey, "has space"), ""},
+		{"generated", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			var got string
+			_, err := RequestIDUnaryServerInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got = RequestIDFromContext(ctx)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == "" {
+				if len(got) != 32 || got == "has space" {
+					t.Errorf("id = %q, want a generated one", got)
+				}
+			} else if got != tt.want {
+				t.Errorf("id = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIDUnaryClientInterceptor(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"forwarded", requestid.NewContext(context.Background(), "abc-123"), []string{"abc-123"}},
+		{"none to forward", context.Background(), nil},
+		{"already set by the caller", metadata.AppendToOutgoingContext(requestid.NewContext(context.Background(), "abc-123"), requestid.MetadataKey, "mine"), []string{"mine"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := RequestIDUnaryClientInterceptor(tt.ctx, testMethod, nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				got = md.Get(requestid.MetadataKey)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) || len(got) > 0 && got[0] != tt.want[0] {
+				t.Errorf("%s = %q, want %q", requestid.MetadataKey, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DocHQ/helpers/tracing/tracingtest"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = "/dochq.patients.v1.Patients/GetPatient"
+
+func initTracing(t *testing.T) *tracingtest.Recorder {
+	t.Helper()
+	provider := tracingtest.Init()
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return provider
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingUnaryServerInterceptor(t *testing.T) {
+	provider := initTracing(t)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("traceparent", "00-"+traceID+"-"+parentID+"-01"))
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+
+	var handlerSpan trace.SpanContext
+	_, err := TracingUnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := provider.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "dochq.patients.v1.Patients/GetPatient" {
+		t.Errorf("span name = %q", span.Name)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span kind = %v, want server", span.SpanKind)
+	}
+	if got := span.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace id = %s, want %s", got, traceID)
+	}
+	if got := span.Parent.SpanID().String(); got != parentID {
+		t.Errorf("parent span id = %s, want %s", got, parentID)
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Error("the handler's context doesn't carry the server span")
+	}
+	if v, _ := attributeValue(span.Attributes, semconv.RPCServiceKey); v.AsString() != "dochq.patients.v1.Patients" {
+		t.Errorf("rpc.service = %q", v.AsString())
+	}
+	if v, _ := attributeValue(span.Attributes, semconv.RPCMethodKey); v.AsString() != "GetPatient" {
+		t.Errorf("rpc.method = %q", v.AsString())
+	}
+	if v, _ := attributeValue(span.Attributes, "rpc.grpc.status_code"); v.AsInt64() != int64(grpccodes.OK) {
+		t.Errorf("rpc.grpc.status_code = %d, want 0", v.AsInt64())
+	}
+}
+
+func TestTracingUnaryServerInterceptorError(t *testing.T) {
+	provider := initTracing(t)
+
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+	_, err := TracingUnaryServerInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(grpccodes.NotFound, "no such patient")
+	})
+	if status.Code(err) != grpccodes.NotFound {
+		t.Fatalf("err = %v", err)
+	}
+
+	spans := provider.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Status.Code != codes.Error || span.Status.Description != "no such patient" {
+		t.Errorf("span status = %+v", span.Status)
+	}
+	if v, _ := attributeValue(span.Attributes, "rpc.grpc.status_code"); v.AsInt64() != int64(grpccodes.NotFound) {
+		t.Errorf("rpc.grpc.status_code = %d, want %d", v.AsInt64(), grpccodes.NotFound)
+	}
+	if len(span.Events) == 0 {
+		t.Error("the error wasn't recorded on the span")
+	}
+}
+
+func TestTracingUnaryClientInterceptor(t *testing.T) {
+	provider := initTracing(t)
+
+	var sent metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		sent, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "abc")
+	if err := TracingUnaryClientInterceptor(ctx, testMethod, nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := provider.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.SpanKind != trace.SpanKindClient {
+		t.Errorf("span kind = %v, want client", span.SpanKind)
+	}
+	want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if got := sent.Get("traceparent"); len(got) != 1 || got[0] != want {
+		t.Errorf("traceparent = %v, want %s", got, want)
+	}
+	if got := sent.Get("x-request-id"); len(got) != 1 || got[0] != "abc" {
+		t.Errorf("existing metadata was lost: %v", got)
+	}
+}
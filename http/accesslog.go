@@ -0,0 +1,281 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	basehttp "net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DocHQ/helpers/requestid"
+
+	"github.com/gorilla/mux"
+)
+
+// AccessLogEntry is a single line of the access log, one is produced for every
+// request that goes through a Router created with New
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	Method    string        `json:"method"`
+	Route     string        `json:"route,omitempty"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Bytes     int           `json:"bytes"`
+	Latency   time.Duration `json:"-"`
+	RemoteIP  string        `json:"remote_ip"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Caller    string        `json:"caller,omitempty"`
+
+	// Aborted is set when the connection was dropped part way through the
+	// response, Status is then what had been sent (500 if nothing had)
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+// AccessLogSink receives the access log entries, implement this to ship them
+// somewhere other than stdout
+type AccessLogSink interface {
+	LogAccess(entry AccessLogEntry)
+}
+
+// AccessLogSinkFunc allows a plain function to be used as an AccessLogSink
+type AccessLogSinkFunc func(entry AccessLogEntry)
+
+// LogAccess calls f(entry)
+func (f AccessLogSinkFunc) LogAccess(entry AccessLogEntry) {
+	f(entry)
+}
+
+// Access log formats understood by NewAccessLogWriter
+const (
+	AccessLogJSON   = "json"
+	AccessLogLogfmt = "logfmt"
+)
+
+// NewAccessLogWriter returns a sink that writes one line per entry to w in the
+// given format (AccessLogJSON or AccessLogLogfmt, anything else is JSON).
+// It is safe to use from multiple goroutines.
+func NewAccessLogWriter(w io.Writer, format string) AccessLogSink {
+	return &accessLogWriter{w: w, logfmt: format == AccessLogLogfmt}
+}
+
+// SetAccessLogSink replaces the sink used by the access log middleware.
+// Passing nil turns the access log off.
+// The default writes to stdout in the format named by HTTP_ACCESS_LOG_FORMAT.
+func SetAccessLogSink(sink AccessLogSink) {
+	accessLogSink = sink
+}
+
+// SetCaller records who is making the request so that it appears in the
+// access log, typically called by authentication middleware once it knows.
+func SetCaller(r *basehttp.Request, caller string) {
+	if state, ok := r.Context().Value(accessLogStateKey{}).(*accessLogState); ok {
+		state.caller = caller
+	}
+}
+
+// Caller returns the caller recorded by SetCaller, or "" if there isn't one
+func Caller(ctx context.Context) string {
+	if state, ok := ctx.Value(accessLogStateKey{}).(*accessLogState); ok {
+		return state.caller
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+var accessLogSink AccessLogSink = NewAccessLogWriter(os.Stdout, os.Getenv("HTTP_ACCESS_LOG_FORMAT"))
+
+type accessLogStateKey struct{}
+
+// accessLogState is shared with the handlers further down the chain through
+// the request context so they can fill in things only they know
+type accessLogState struct {
+	caller string
+	start  time.Time
+}
+
+func accessLogMiddleware(next basehttp.Handler) basehttp.Handler {
+	return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		start := time.Now()
+		path := r.RequestURI
+		sw := statusWriter{ResponseWriter: w}
+		state := &accessLogState{start: start}
+
+		// Recovery passes http.ErrAbortHandler on so the server drops the
+		// connection, log those requests on the way past
+		aborted := true
+		defer func() {
+			if !aborted {
+				return
+			}
+			p := recover()
+			logAccess(r, &sw, state, path, true)
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		next.ServeHTTP(&sw, r.WithContext(context.WithValue(r.Context(), accessLogStateKey{}, state)))
+		aborted = false
+		logAccess(r, &sw, state, path, false)
+	})
+}
+
+func logAccess(r *basehttp.Request, sw *statusWriter, state *accessLogState, path string, aborted bool) {
+	// Where Google spams the /health endpoint constantly,
+	// skip it in the logs
+	if isHealthPath(r.URL.Path) && os.Getenv("DEBUG_WITH_HEALTH") != "true" {
+		return
+	}
+
+	sink := accessLogSink
+	if sink == nil {
+		return
+	}
+
+	entry := AccessLogEntry{
+		Time:      state.start,
+		RequestID: requestid.FromContext(r.Context()),
+		Method:    r.Method,
+		Path:      path,
+		Status:    sw.status,
+		Bytes:     sw.length,
+		Latency:   time.Since(state.start),
+		RemoteIP:  clientIP(r),
+		UserAgent: r.UserAgent(),
+		Caller:    state.caller,
+		Aborted:   aborted,
+	}
+	if aborted && entry.Status == 0 {
+		entry.Status = basehttp.StatusInternalServerError
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		entry.Route, _ = route.GetPathTemplate()
+	}
+	if entry.Caller == "" {
+		entry.Caller = callerFromAuthorization(r.Header.Get("Authorization"))
+	}
+
+	sink.LogAccess(entry)
+}
+
+// isHealthPath matches only the health routes New registers, so a service's
+// own /health/... routes are still logged and authorized
+func isHealthPath(path string) bool {
+	switch path {
+	case "/health", "/health/live", "/health/ready":
+		return true
+	}
+	return false
+}
+
+// callerFromAuthorization identifies the caller without logging the
+// credential: the user name for Basic auth, otherwise a short hash of the
+// token, enough to tell callers apart and match a key you already hold
+func callerFromAuthorization(header string) string {
+	fields := strings.Fields(header)
+	if len(fields) == 0 {
+		return ""
+	}
+	if len(fields) == 2 && strings.EqualFold(fields[0], "Basic") {
+		if decoded, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+			if i := bytes.IndexByte(decoded, ':'); i > 0 {
+				return string(decoded[:i])
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(fields[len(fields)-1]))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// clientIP returns the address of the client, preferring the first address in
+// X-Forwarded-For as we always sit behind a load balancer. The client can set
+// that to anything, so this is only for the log, see trustedClientIP.
+func clientIP(r *basehttp.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		if i := strings.IndexByte(fwd, ','); i >= 0 {
+			fwd = fwd[:i]
+		}
+		return strings.TrimSpace(fwd)
+	}
+	return remoteHost(r)
+}
+
+// remoteHost is the address the connection came from
+func remoteHost(r *basehttp.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type accessLogWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	logfmt bool
+}
+
+func (l *accessLogWriter) LogAccess(entry AccessLogEntry) {
+	var line []byte
+	if l.logfmt {
+		line = entry.appendLogfmt(nil)
+	} else {
+		var err error
+		line, err = json.Marshal(struct {
+			AccessLogEntry
+			LatencyMS float64 `json:"latency_ms"`
+		}{entry, float64(entry.Latency) / float64(time.Millisecond)})
+		if err != nil {
+			return
+		}
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+func (e AccessLogEntry) appendLogfmt(b []byte) []byte {
+	pair := func(key, value string) {
+		if len(b) > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, key...)
+		b = append(b, '=')
+		if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+			b = strconv.AppendQuote(b, value)
+		} else {
+			b = append(b, value...)
+		}
+	}
+
+	pair("time", e.Time.Format(time.RFC3339Nano))
+	pair("request_id", e.RequestID)
+	pair("method", e.Method)
+	pair("route", e.Route)
+	pair("path", e.Path)
+	pair("status", strconv.Itoa(e.Status))
+	pair("bytes", strconv.Itoa(e.Bytes))
+	pair("latency_ms", fmt.Sprintf("%.3f", float64(e.Latency)/float64(time.Millisecond)))
+	pair("remote_ip", e.RemoteIP)
+	pair("user_agent", e.UserAgent)
+	pair("caller", e.Caller)
+	if e.Aborted {
+		pair("aborted", "true")
+	}
+	return b
+}
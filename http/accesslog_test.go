@@ -0,0 +1,173 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	basehttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureAccessLog swaps the sink for one that keeps the entries for the
+// length of the test
+func captureAccessLog(t *testing.T) *[]AccessLogEntry {
+	t.Helper()
+	var entries []AccessLogEntry
+	sink := accessLogSink
+	SetAccessLogSink(AccessLogSinkFunc(func(entry AccessLogEntry) {
+		entries = append(entries, entry)
+	}))
+	t.Cleanup(func() { SetAccessLogSink(sink) })
+	return &entries
+}
+
+func TestCallerFromAuthorization(t *testing.T) {
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("clinic-portal:s3cret"))
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"none", "", ""},
+		{"basic", basic, "clinic-portal"},
+		{"basic without a user", "Basic " + base64.StdEncoding.EncodeToString([]byte(":s3cret")), "sha256:"},
+		{"bearer", "Bearer s3cret", "sha256:"},
+		{"bare key", "s3cret", "sha256:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := callerFromAuthorization(tt.header)
+			if tt.want == "sha256:" {
+				if !strings.HasPrefix(got, "sha256:") || len(got) != len("sha256:")+12 {
+					t.Errorf("callerFromAuthorization(%q) = %q, want a short hash", tt.header, got)
+				}
+			} else if got != tt.want {
+				t.Errorf("callerFromAuthorization(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+			if strings.Contains(got, "s3cret") {
+				t.Errorf("callerFromAuthorization(%q) = %q leaks the credential", tt.header, got)
+			}
+		})
+	}
+
+	if callerFromAuthorization("Bearer s3cret") != callerFromAuthorization("s3cret") {
+		t.Error("the same key should hash the same with or without a scheme")
+	}
+	if callerFromAuthorization("Bearer s3cret") == callerFromAuthorization("Bearer other") {
+		t.Error("different keys should hash differently")
+	}
+}
+
+func TestAccessLogEntry(t *testing.T) {
+	entries := captureAccessLog(t)
+
+	router := New()
+	router.HandleFunc("/patients/{id}", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		SetCaller(r, "clinic-portal")
+		w.WriteHeader(basehttp.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	router.HandleFunc("/anonymous", func(w basehttp.ResponseWriter, r *basehttp.Request) {})
+
+	tests := []struct {
+		name   string
+		path   string
+		header basehttp.Header
+		want   AccessLogEntry
+	}{
+		{
+			name:   "route",
+			path:   "/patients/42?full=1",
+			header: basehttp.Header{"X-Request-Id": {"abc-123"}, "User-Agent": {"test"}, "X-Forwarded-For": {"203.0.113.7, 10.0.0.1"}},
+			want: AccessLogEntry{
+				RequestID: "abc-123", Method: "GET", Route: "/patients/{id}", Path: "/patients/42?full=1",
+				Status: 201, Bytes: 5, RemoteIP: "203.0.113.7", UserAgent: "test", Caller: "clinic-portal",
+			},
+		},
+		{
+			name:   "caller from the key",
+			path:   "/anonymous",
+			header: basehttp.Header{"X-Request-Id": {"abc-123"}, "Authorization": {"s3cret"}},
+			want: AccessLogEntry{
+				RequestID: "abc-123", Method: "GET", Route: "/anonymous", Path: "/anonymous",
+				RemoteIP: "192.0.2.1", Caller: callerFromAuthorization("s3cret"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*entries = nil
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header = tt.header
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			if len(*entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(*entries))
+			}
+			got := (*entries)[0]
+			if got.Time.IsZero() || got.Latency < 0 {
+				t.Errorf("time = %v, latency = %v", got.Time, got.Latency)
+			}
+			got.Time, got.Latency = time.Time{}, 0
+			if got != tt.want {
+				t.Errorf("entry = %+v\nwant    %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogSkipsHealth(t *testing.T) {
+	entries := captureAccessLog(t)
+	router := New()
+	router.HandleFunc("/health/extra", func(w basehttp.ResponseWriter, r *basehttp.Request) {})
+
+	for _, path := range []string{"/health", "/health/live", "/health/ready", "/health/extra"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if len(*entries) != 1 || (*entries)[0].Path != "/health/extra" {
+		t.Errorf("entries = %+v, want only /health/extra", *entries)
+	}
+}
+
+func TestAccessLogWriter(t *testing.T) {
+	entry := AccessLogEntry{
+		Time:      time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Method:    "GET",
+		Path:      "/patients/42",
+		Status:    200,
+		Bytes:     5,
+		Latency:   1500 * time.Microsecond,
+		RemoteIP:  "203.0.113.7",
+		UserAgent: "Mozilla/5.0 (X11)",
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{AccessLogLogfmt, `time=2021-06-01T12:00:00Z request_id="" method=GET route="" path=/patients/42 status=200 bytes=5 latency_ms=1.500 remote_ip=203.0.113.7 user_agent="Mozilla/5.0 (X11)" caller=""` + "\n"},
+		{AccessLogJSON, `{"time":"2021-06-01T12:00:00Z","method":"GET","path":"/patients/42","status":200,"bytes":5,"remote_ip":"203.0.113.7","user_agent":"Mozilla/5.0 (X11)","latency_ms":1.5}` + "\n"},
+		{"", `{"time":"2021-06-01T12:00:00Z","method":"GET","path":"/patients/42","status":200,"bytes":5,"remote_ip":"203.0.113.7","user_agent":"Mozilla/5.0 (X11)","latency_ms":1.5}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			NewAccessLogWriter(&buf, tt.format).LogAccess(entry)
+			if buf.String() != tt.want {
+				t.Errorf("got  %s\nwant %s", buf.String(), tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	entry.Aborted = true
+	NewAccessLogWriter(&buf, AccessLogJSON).LogAccess(entry)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded["aborted"] != true {
+		t.Errorf("aborted entry = %s", buf.String())
+	}
+}
@@ -0,0 +1,457 @@
+package http
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	basehttp "net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings understood by Compress and DecompressRequest
+const (
+	EncodingBrotli  = "br"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// DefaultCompressMinSize is the smallest body Compress will bother with, below
+// this the headers and framing cost more than is saved
+const DefaultCompressMinSize = 1024
+
+// MaxDecompressedRequestSize stops DecompressRequest inflating a small body
+// into something that exhausts memory
+var MaxDecompressedRequestSize int64 = 32 << 20
+
+// CompressOptions configures the Compress middleware, the zero value is usable
+type CompressOptions struct {
+	// MinSize is the smallest body that is compressed, DefaultCompressMinSize if 0
+	MinSize int
+
+	// Level is passed to the encoder, 0 uses each encoder's default
+	Level int
+
+	// Encodings lists the codings to offer in order of preference when the
+	// client rates them equally, br, gzip and deflate if empty
+	Encodings []string
+
+	// SkipContentTypes lists media types (or type/ prefixes) that are never
+	// compressed, in addition to those that are already compressed such as
+	// images, video and archives
+	SkipContentTypes []string
+}
+
+// WithCompression compresses the Router's responses, see Compress
+func WithCompression(opts CompressOptions) Option {
+	return func(r *Router) {
+		r.Use(Compress(opts))
+	}
+}
+
+// Compress is middleware that compresses response bodies with the best coding
+// the client accepts (br, gzip or deflate from Accept-Encoding).
+//
+// The status and headers are held back until MinSize bytes have been written
+// (or the handler flushes or returns) so small bodies, already compressed
+// content types and responses that already have a Content-Encoding are sent
+// as they are. This means it works with Respond, which writes the status
+// first and then streams the body. Vary: Accept-Encoding is always set.
+func Compress(opts CompressOptions) func(basehttp.Handler) basehttp.Handler {
+	if opts.MinSize <= 0 {
+		opts.MinSize = DefaultCompressMinSize
+	}
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{EncodingBrotli, EncodingGzip, EncodingDeflate}
+	}
+	pools := newEncoderPools(opts.Level)
+
+	return func(next basehttp.Handler) basehttp.Handler {
+		return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+
+			// Handlers compare the conditional headers with the ETag
+			// of the uncompressed body. The caller's request is left as
+			// it was.
+			ifNoneMatch := r.Header.Get("If-None-Match")
+			if ifNoneMatch != "" || r.Header.Get("If-Match") != "" {
+				r = r.Clone(r.Context())
+				for _, name := range []string{"If-Match", "If-None-Match"} {
+					if value := r.Header.Get(name); value != "" {
+						r.Header.Set(name, stripCodingETags(value, opts.Encodings))
+					}
+				}
+			}
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), opts.Encodings)
+			if encoding == "" || r.Method == basehttp.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, opts: &opts, pools: pools, encoding: encoding, ifNoneMatch: ifNoneMatch}
+			next.ServeHTTP(cw, r)
+
+			// Not deferred, if the handler panics the connection is going
+			// to be dropped and nothing more should be written
+			cw.close()
+		})
+	}
+}
+
+// DecompressRequest is middleware that transparently decodes request bodies
+// sent with Content-Encoding gzip or deflate, so handlers decode the body the
+// same way whether or not it was compressed. Other codings get a 415.
+func DecompressRequest(next basehttp.Handler) basehttp.Handler {
+	return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" || r.Body == nil || r.Body == basehttp.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body io.ReadCloser
+		var err error
+		switch encoding {
+		case EncodingGzip, "x-gzip":
+			body, err = gzip.NewReader(r.Body)
+		case EncodingDeflate:
+			body, err = zlib.NewReader(r.Body)
+		default:
+			RespondError(w, r, basehttp.StatusUnsupportedMediaType, "Unsupported Content-Encoding: "+encoding)
+			return
+		}
+		if err != nil {
+			RespondError(w, r, basehttp.StatusBadRequest, "Malformed "+encoding+" request body")
+			return
+		}
+		defer body.Close()
+
+		r.Body = basehttp.MaxBytesReader(w, body, MaxDecompressedRequestSize)
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// alreadyCompressed lists content types that don't get smaller, plus event
+// streams where the encoder's buffering would hold events back
+var alreadyCompressed = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-bzip2", "application/x-7z-compressed", "application/x-rar-compressed",
+	"application/pdf", "application/octet-stream", "application/wasm",
+	"text/event-stream",
+}
+
+type compressWriter struct {
+	basehttp.ResponseWriter
+	opts     *CompressOptions
+	pools    *encoderPools
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+
+	// ifNoneMatch is the header as the client sent it, before the codings
+	// were taken off
+	ifNoneMatch string
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	// Informational responses go straight through
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = basehttp.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.opts.MinSize {
+			return len(b), nil
+		}
+		cw.decide(true)
+		if err := cw.flushBuffer(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far, needed for streaming responses
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = basehttp.StatusOK
+		}
+		// The final size is unknown once the handler starts flushing
+		cw.decide(true)
+		cw.flushBuffer()
+	}
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(basehttp.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, as net/http's ResponseController expects
+func (cw *compressWriter) Unwrap() basehttp.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Hijack passes through so websockets still work behind the middleware
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(basehttp.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("http: response does not implement http.Hijacker")
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// The handler wrote nothing at all
+			return
+		}
+		cw.decide(false)
+		cw.flushBuffer()
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		cw.pools.put(cw.encoding, cw.encoder)
+		cw.encoder = nil
+	}
+}
+
+// decide sends the headers, compressing if the body is big enough and of a
+// type worth compressing
+func (cw *compressWriter) decide(bigEnough bool) {
+	cw.decided = true
+	header := cw.Header()
+
+	if bigEnough && cw.shouldCompress(header) {
+		header.Set("Content-Encoding", cw.encoding)
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", codingETag(etag, cw.encoding))
+		}
+		header.Del("Content-Length")
+		cw.encoder = cw.pools.get(cw.encoding, cw.ResponseWriter)
+	} else if cw.status == basehttp.StatusNotModified {
+		// Tell the client the compressed copy it has is still good
+		if etag := header.Get("ETag"); etag != "" && etagListMatches(cw.ifNoneMatch, codingETag(etag, cw.encoding), true) {
+			header.Set("ETag", codingETag(etag, cw.encoding))
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) shouldCompress(header basehttp.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if cw.status == basehttp.StatusNoContent || cw.status == basehttp.StatusNotModified || cw.status == basehttp.StatusPartialContent {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = basehttp.DetectContentType(cw.buf)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	// Entries are prefixes so "image/" covers every image type
+	for _, list := range [][]string{alreadyCompressed, cw.opts.SkipContentTypes} {
+		for _, skip := range list {
+			if strings.HasPrefix(mediaType, skip) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) flushBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// negotiateEncoding picks the coding from Accept-Encoding with the highest
+// q-value, using the order of supported to break ties
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	q := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params := part, ""
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			name, params = part[:i], part[i+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			if v, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				weight = v
+			}
+		}
+		if name == "x-gzip" {
+			name = EncodingGzip
+		}
+		q[name] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		weight, ok := q[encoding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = encoding, weight
+		}
+	}
+	return best
+}
+
+// codingETag gives a compressed representation its own entity tag
+func codingETag(etag, coding string) string {
+	if len(etag) < 2 || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + coding + `"`
+}
+
+// stripCodingETags takes the codings codingETag added off a list of entity tags
+func stripCodingETags(value string, codings []string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		tag := strings.TrimSpace(part)
+		for _, coding := range codings {
+			if suffix := "-" + coding + `"`; strings.HasSuffix(tag, suffix) {
+				tag = tag[:len(tag)-len(suffix)] + `"`
+				break
+			}
+		}
+		parts[i] = tag
+	}
+	return strings.Join(parts, ", ")
+}
+
+// addVary adds a field to Vary without repeating it
+func addVary(header basehttp.Header, field string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) || strings.TrimSpace(existing) == "*" {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
+
+// encoderPools reuses encoders as they allocate large buffers
+type encoderPools struct {
+	level                 int
+	brotli, gzip, deflate sync.Pool
+}
+
+func newEncoderPools(level int) *encoderPools {
+	return &encoderPools{level: level}
+}
+
+func (p *encoderPools) get(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case EncodingBrotli:
+		if enc, ok := p.brotli.Get().(*brotli.Writer); ok {
+			enc.Reset(w)
+			return enc
+		}
+		level := p.level
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level)
+	case EncodingDeflate:
+		if enc, ok := p.deflate.Get().(*zlib.Writer); ok {
+			enc.Reset(w)
+			return enc
+		}
+		enc, err := zlib.NewWriterLevel(w, p.compressLevel())
+		if err != nil {
+			return zlib.NewWriter(w)
+		}
+		return enc
+	default:
+		if enc, ok := p.gzip.Get().(*gzip.Writer); ok {
+			enc.Reset(w)
+			return enc
+		}
+		enc, err := gzip.NewWriterLevel(w, p.compressLevel())
+		if err != nil {
+			return gzip.NewWriter(w)
+		}
+		return enc
+	}
+}
+
+// compressLevel maps our "0 is the default" onto compress/flate, where 0
+// means no compression
+func (p *encoderPools) compressLevel() int {
+	if p.level == 0 {
+		return gzip.DefaultCompression
+	}
+	return p.level
+}
+
+func (p *encoderPools) put(encoding string, enc io.WriteCloser) {
+	switch encoding {
+	case EncodingBrotli:
+		p.brotli.Put(enc)
+	case EncodingDeflate:
+		p.deflate.Put(enc)
+	default:
+		p.gzip.Put(enc)
+	}
+}
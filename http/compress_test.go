@@ -0,0 +1,111 @@
+package http
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	basehttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressETagPerCoding(t *testing.T) {
+	body := map[string]string{"text": strings.Repeat("compress me ", 200)}
+	handler := Compress(CompressOptions{})(basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		if r.Method == basehttp.MethodPut {
+			if CheckPreconditions(w, r, `"v1"`, time.Time{}) {
+				w.WriteHeader(basehttp.StatusNoContent)
+			}
+			return
+		}
+		RespondCached(w, r, basehttp.StatusOK, body, CacheOptions{ETag: "v1"})
+	}))
+
+	serve := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept", "application/json")
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := serve("GET", map[string]string{"Accept-Encoding": "gzip"})
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1-gzip"` {
+		t.Errorf("ETag = %s, want \"v1-gzip\"", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := ioutil.ReadAll(zr); !strings.Contains(string(decoded), "compress me") {
+		t.Errorf("body didn't decompress: %.40q", decoded)
+	}
+
+	rec = serve("GET", nil)
+	if got := rec.Header().Get("ETag"); got != `"v1"` {
+		t.Errorf("uncompressed ETag = %s, want \"v1\"", got)
+	}
+
+	rec = serve("GET", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `"v1-gzip"`})
+	if rec.Code != basehttp.StatusNotModified {
+		t.Errorf("revalidating the gzip copy: status = %d, want 304", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1-gzip"` {
+		t.Errorf("304 ETag = %s, want \"v1-gzip\"", got)
+	}
+
+	rec = serve("PUT", map[string]string{"Accept-Encoding": "gzip", "If-Match": `"v1-gzip"`})
+	if rec.Code != basehttp.StatusNoContent {
+		t.Errorf("If-Match with the gzip ETag: status = %d, want 204", rec.Code)
+	}
+	rec = serve("PUT", map[string]string{"If-Match": `"v0-gzip"`})
+	if rec.Code != basehttp.StatusPreconditionFailed {
+		t.Errorf("If-Match with a stale ETag: status = %d, want 412", rec.Code)
+	}
+}
+
+func TestCompressConditionalHeaders(t *testing.T) {
+	body := map[string]string{"text": strings.Repeat("compress me ", 200)}
+	handler := Compress(CompressOptions{})(basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		RespondCached(w, r, basehttp.StatusOK, body, CacheOptions{ETag: "v1"})
+	}))
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+		wantETag    string
+	}{
+		{name: "gzip copy", ifNoneMatch: `"v1-gzip"`, wantStatus: 304, wantETag: `"v1-gzip"`},
+		{name: "identity copy", ifNoneMatch: `"v1"`, wantStatus: 304, wantETag: `"v1"`},
+		{name: "weak gzip copy in a list", ifNoneMatch: `"v0", W/"v1-gzip"`, wantStatus: 304, wantETag: `"v1-gzip"`},
+		{name: "any", ifNoneMatch: `*`, wantStatus: 304, wantETag: `"v1-gzip"`},
+		{name: "longer tag", ifNoneMatch: `"v1-gzip-old"`, wantStatus: 200, wantETag: `"v1-gzip"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept", "application/json")
+			r.Header.Set("Accept-Encoding", "gzip")
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, test.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); got != test.wantETag {
+				t.Errorf("ETag = %s, want %s", got, test.wantETag)
+			}
+			if got := r.Header.Get("If-None-Match"); got != test.ifNoneMatch {
+				t.Errorf("the caller's If-None-Match was changed to %s", got)
+			}
+		})
+	}
+}
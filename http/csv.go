@@ -0,0 +1,357 @@
+package http
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	nh "net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content types for spreadsheet friendly responses. Respond produces these
+// when they are the Accept header, turning a slice into one row per element.
+//
+// Columns come from the csv struct tag, then the json tag, then the field
+// name, in field order, and csv:"-" leaves a field out. Nested structs are
+// flattened into "parent.child" columns, maps and slices are written as JSON
+// and times as RFC 3339. Text that a spreadsheet would run as a formula is
+// prefixed with a single quote.
+const (
+	ContentTypeCSV = "text/csv"
+	ContentTypeTSV = "text/tab-separated-values"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// encodeCSV writes response as delimited rows with a header row. A slice or
+// array gives a row per element, anything else a single row.
+func encodeCSV(w io.Writer, response interface{}, comma rune) error {
+	// A page of results is downloaded as just the results
+	if page, ok := response.(Page); ok {
+		response = page.Items
+	}
+
+	var rows []reflect.Value
+	var rowType reflect.Type
+	v := indirect(reflect.ValueOf(response))
+	if v.IsValid() && (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+		rowType = v.Type().Elem()
+		rows = make([]reflect.Value, v.Len())
+		for i := range rows {
+			rows[i] = indirect(v.Index(i))
+		}
+	} else if v.IsValid() {
+		rowType = v.Type()
+		rows = []reflect.Value{v}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	header, cells, err := csvLayout(rowType, rows)
+	if err != nil {
+		return err
+	}
+	if len(header) == 0 {
+		// Only an empty list of maps has no columns at all
+		return nil
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, row := range rows {
+		if err := cells(row, record); err != nil {
+			return err
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvLayout decides the columns from the type of the rows, so an empty slice
+// still gets its header, or from the first row for a slice of interfaces. The
+// rows must all be structs of the same type, all maps, or all single values.
+func csvLayout(rowType reflect.Type, rows []reflect.Value) ([]string, func(reflect.Value, []string) error, error) {
+	for rowType != nil && rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	if rowType == nil || rowType.Kind() == reflect.Interface {
+		rowType = nil
+		for _, row := range rows {
+			if row.IsValid() {
+				rowType = row.Type()
+				break
+			}
+		}
+	}
+	kind := reflect.Invalid
+	if rowType != nil {
+		kind = rowType.Kind()
+	}
+
+	switch {
+	case kind == reflect.Struct && !isCSVValue(rowType):
+		columns := csvColumnsFor(rowType)
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.name
+		}
+		return header, func(row reflect.Value, record []string) error {
+			if row.IsValid() && row.Type() != rowType {
+				return fmt.Errorf("[csv] rows must all be the same type, found %s and %s", rowType, row.Type())
+			}
+			for i, c := range columns {
+				cell, err := csvCell(csvField(row, c.index))
+				if err != nil {
+					return err
+				}
+				record[i] = cell
+			}
+			return nil
+		}, nil
+
+	case kind == reflect.Map && rowType.Key().Kind() == reflect.String:
+		// The union of the keys, sorted as maps have no order
+		seen := map[string]bool{}
+		var header []string
+		for _, row := range rows {
+			if row.Kind() != reflect.Map {
+				continue
+			}
+			for _, key := range row.MapKeys() {
+				if name := key.String(); !seen[name] {
+					seen[name] = true
+					header = append(header, name)
+				}
+			}
+		}
+		sort.Strings(header)
+		return header, func(row reflect.Value, record []string) error {
+			if row.IsValid() && row.Kind() != reflect.Map {
+				return fmt.Errorf("[csv] rows must all be the same type, found %s and %s", rowType, row.Type())
+			}
+			for i, name := range header {
+				var value reflect.Value
+				if row.IsValid() {
+					value = row.MapIndex(reflect.ValueOf(name).Convert(row.Type().Key()))
+				}
+				cell, err := csvCell(value)
+				if err != nil {
+					return err
+				}
+				record[i] = cell
+			}
+			return nil
+		}, nil
+
+	default:
+		return []string{"value"}, func(row reflect.Value, record []string) error {
+			cell, err := csvCell(row)
+			record[0] = cell
+			return err
+		}, nil
+	}
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvColumns caches the columns of each struct type
+var csvColumns sync.Map
+
+func csvColumnsFor(t reflect.Type) []csvColumn {
+	if columns, ok := csvColumns.Load(t); ok {
+		return columns.([]csvColumn)
+	}
+	columns := appendCSVColumns(nil, t, "", nil)
+	csvColumns.Store(t, columns)
+	return columns
+}
+
+func appendCSVColumns(columns []csvColumn, t reflect.Type, prefix string, index []int) []csvColumn {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		name, named := csvFieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		fieldIndex := append(append([]int{}, index...), i)
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && !isCSVValue(ft) {
+			// Embedded structs are promoted like encoding/json does
+			if field.Anonymous && !named {
+				columns = appendCSVColumns(columns, ft, prefix, fieldIndex)
+			} else {
+				columns = appendCSVColumns(columns, ft, prefix+name+".", fieldIndex)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue // embedded unexported non-struct
+		}
+		columns = append(columns, csvColumn{name: prefix + name, index: fieldIndex})
+	}
+	return columns
+}
+
+// csvFieldName returns the column name for a field and whether it came from a tag
+func csvFieldName(field reflect.StructField) (string, bool) {
+	for _, key := range []string{"csv", "json"} {
+		tag, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name, true
+		}
+	}
+	return field.Name, false
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isCSVValue reports whether a struct type is written as one cell rather
+// than flattened into columns
+func isCSVValue(t reflect.Type) bool {
+	return t == timeType || t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)
+}
+
+// csvField is FieldByIndex, returning an invalid value if a pointer on the
+// way is nil instead of panicking
+func csvField(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		v = indirect(v)
+		if !v.IsValid() {
+			return v
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// indirect follows pointers and interfaces, returning an invalid value for nil
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func csvCell(v reflect.Value) (string, error) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return "", nil
+	}
+
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(time.RFC3339), nil
+	}
+	if v.Type().Implements(textMarshalerType) || (v.CanAddr() && v.Addr().Type().Implements(textMarshalerType)) {
+		m, ok := v.Interface().(encoding.TextMarshaler)
+		if !ok {
+			m = v.Addr().Interface().(encoding.TextMarshaler)
+		}
+		text, err := m.MarshalText()
+		return csvSafe(string(text)), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return csvSafe(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Map:
+		if v.IsNil() {
+			return "", nil
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return "", nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+		}
+	}
+
+	// Maps, slices and anything else are written as JSON
+	out := getBuffer()
+	defer putBuffer(out)
+	if err := jsonCodec(EncodeOptions{}).encode(out, v.Interface()); err != nil {
+		return "", err
+	}
+	return csvSafe(out.String()), nil
+}
+
+// csvSafe stops spreadsheets treating text as a formula (CSV injection)
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// setContentDisposition makes browsers download CSV and TSV responses, named
+// after the last part of the request path, unless the handler already chose
+func setContentDisposition(header nh.Header, r *nh.Request, contentType string) {
+	ext := ""
+	switch contentType {
+	case ContentTypeCSV:
+		ext = ".csv"
+	case ContentTypeTSV:
+		ext = ".tsv"
+	default:
+		return
+	}
+	if header.Get("Content-Disposition") != "" {
+		return
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, path.Base(r.URL.Path))
+	if strings.Trim(name, ".") == "" {
+		name = "export"
+	}
+	header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, ext))
+}
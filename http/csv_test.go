@@ -0,0 +1,116 @@
+package http
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type csvAddress struct {
+	City     string `json:"city"`
+	Postcode string `csv:"postcode" json:"post_code"`
+}
+
+type csvPatient struct {
+	ID      int               `json:"id"`
+	Name    string            `json:"name"`
+	Born    time.Time         `json:"born"`
+	Address *csvAddress       `json:"address"`
+	Tags    []string          `json:"tags"`
+	Notes   string            `csv:"-"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	secret  string
+}
+
+func TestEncodeCSV(t *testing.T) {
+	born := time.Date(1980, 2, 1, 0, 0, 0, 0, time.UTC)
+	header := "id,name,born,address.city,address.postcode,tags,extra\n"
+
+	tests := []struct {
+		name     string
+		response interface{}
+		comma    rune
+		want     string
+	}{
+		{
+			name: "structs",
+			response: []csvPatient{
+				{ID: 1, Name: "Jane Doe", Born: born, Address: &csvAddress{"London", "N1 9GU"}, Tags: []string{"a", "b"}, secret: "x"},
+				{ID: 2, Name: "John, Smith"},
+			},
+			comma: ',',
+			want: header +
+				`1,Jane Doe,1980-02-01T00:00:00Z,London,N1 9GU,"[""a"",""b""]",` + "\n" +
+				`2,"John, Smith",,,,,` + "\n",
+		},
+		{"empty slice still has a header", []csvPatient{}, ',', header},
+		{"nil slice still has a header", []*csvPatient(nil), ',', header},
+		{"single struct", csvPatient{ID: 3}, ',', header + "3,,,,,,\n"},
+		{"tsv", []csvAddress{{"London", "N1 9GU"}}, '\t', "city\tpostcode\nLondon\tN1 9GU\n"},
+		{"page", Page{Items: []csvAddress{{"Leeds", "LS1"}}}, ',', "city,postcode\nLeeds,LS1\n"},
+		{
+			name:     "maps",
+			response: []map[string]interface{}{{"b": 1, "a": "x"}, {"c": true}},
+			comma:    ',',
+			want:     "a,b,c\nx,1,\n,,true\n",
+		},
+		{"empty maps", []map[string]string{}, ',', ""},
+		{"values", []int{1, 2}, ',', "value\n1\n2\n"},
+		{"interfaces", []interface{}{csvAddress{"York", "YO1"}}, ',', "city,postcode\nYork,YO1\n"},
+		{
+			name:     "formulas",
+			response: []string{"=1+1", "+44 20", "-1", "@SUM(A1)", "plain"},
+			comma:    ',',
+			want:     "value\n'=1+1\n'+44 20\n'-1\n'@SUM(A1)\nplain\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeCSV(&buf, tt.response, tt.comma); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeCSVMixedRows(t *testing.T) {
+	var buf bytes.Buffer
+	err := encodeCSV(&buf, []interface{}{csvAddress{"York", "YO1"}, csvPatient{ID: 1}}, ',')
+	if err == nil {
+		t.Error("expected an error for rows of different types")
+	}
+}
+
+func TestRespondCSV(t *testing.T) {
+	tests := []struct {
+		accept          string
+		path            string
+		wantType        string
+		wantDisposition string
+	}{
+		{"text/csv", "/patients/export", "text/csv", `attachment; filename="export.csv"`},
+		{"text/tab-separated-values", "/patients", "text/tab-separated-values", `attachment; filename="patients.tsv"`},
+		{"text/csv", "/", "text/csv", `attachment; filename="export.csv"`},
+		{"application/json", "/patients", "application/json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			Respond(w, r, 200, []csvAddress{{"London", "N1 9GU"}})
+
+			if got := w.Header().Get("Content-Type"); got != tt.wantType && got != tt.wantType+"; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantDisposition)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	nh "net/http"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// ErrUnsupportedMediaType is wrapped by the errors Decode returns when it
+// can't read the Content-Type or charset of a request
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// Decode reads the request body into v according to its Content-Type: JSON
+// (also when there is none), CBOR or XML, including types with a +json, +xml
+// or +cbor suffix such as application/merge-patch+json. Parameters are
+// allowed, and bodies in another charset than UTF-8 (e.g. ISO-8859-1,
+// windows-1252 or UTF-16) are transcoded.
+// XML is decoded with encoding/xml, so uses the xml tags.
+func Decode(r *nh.Request, v interface{}) error {
+	contentType, charset, err := decideContentType(r.Header)
+	if err != nil {
+		return err
+	}
+	body, err := charsetReader(charset, r.Body)
+	if err != nil {
+		return err
+	}
+
+	switch contentType {
+	case "application/json", ContentTypeFHIRJSON:
+		return codec.NewDecoder(body, jsonCodec(EncodeOptions{}).handle).Decode(v)
+	case "application/cbor":
+		return codec.NewDecoder(body, cborCodec.handle).Decode(v)
+	case "application/xml":
+		dec := xml.NewDecoder(body)
+		dec.CharsetReader = charsetReader
+		if charset != "" {
+			// Already transcoded, the charset parameter beats the declaration
+			dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+		}
+		return dec.Decode(v)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, contentType)
+}
+
+// DecodeRequest is Decode replying to the client if the body can't be read,
+// 415 Unsupported Media Type for types it can't decode and 400 Bad Request
+// for anything else, through RespondError. The handler should then return:
+//
+//	var patient Patient
+//	if !http.DecodeRequest(w, r, &patient) {
+//		return
+//	}
+func DecodeRequest(w nh.ResponseWriter, r *nh.Request, v interface{}) bool {
+	err := Decode(r, v)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, ErrUnsupportedMediaType) {
+		// Tell the client what it can send instead
+		switch r.Method {
+		case nh.MethodPost:
+			w.Header().Set("Accept-Post", decodableTypes)
+		case nh.MethodPatch:
+			w.Header().Set("Accept-Patch", decodableTypes)
+		}
+		RespondError(w, r, nh.StatusUnsupportedMediaType, err)
+		return false
+	}
+	RespondError(w, r, nh.StatusBadRequest, err)
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// decodableTypes are the types Decode reads, for Accept-Post and Accept-Patch
+const decodableTypes = "application/json, application/cbor, application/xml"
+
+// charsetReader transcodes input from charset to UTF-8. The names are those
+// browsers know (the WHATWG Encoding Standard). It is also an
+// xml.Decoder.CharsetReader, for the encoding in XML declarations.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return input, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: charset %s", ErrUnsupportedMediaType, charset)
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
+}
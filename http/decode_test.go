@@ -0,0 +1,130 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	basehttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type decodePatient struct {
+	Name string `json:"name" codec:"name" xml:"name"`
+}
+
+func TestDecode(t *testing.T) {
+	var cbor bytes.Buffer
+	if err := cborCodec.encode(&cbor, decodePatient{Name: "café"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		wantErr     error
+	}{
+		{name: "no content type is JSON", body: `{"name":"café"}`, want: "café"},
+		{name: "json", contentType: "application/json", body: `{"name":"café"}`, want: "café"},
+		{name: "json with parameters", contentType: "application/json; charset=UTF-8; foo=bar", body: `{"name":"café"}`, want: "café"},
+		{name: "json suffix", contentType: "application/merge-patch+json", body: `{"name":"café"}`, want: "café"},
+		{name: "fhir json", contentType: "application/fhir+json; fhirVersion=4.0", body: `{"name":"café"}`, want: "café"},
+		{name: "cbor", contentType: "application/cbor", body: cbor.String(), want: "café"},
+		{name: "xml", contentType: "application/xml", body: `<patient><name>café</name></patient>`, want: "café"},
+		{name: "text/xml", contentType: "text/xml", body: `<patient><name>café</name></patient>`, want: "café"},
+		{name: "xml suffix", contentType: "application/patient+xml", body: `<patient><name>café</name></patient>`, want: "café"},
+		{name: "latin-1 json", contentType: "application/json; charset=ISO-8859-1", body: "{\"name\":\"caf\xe9\"}", want: "café"},
+		{name: "latin-1 xml", contentType: "application/xml; charset=iso-8859-1", body: "<patient><name>caf\xe9</name></patient>", want: "café"},
+		{name: "latin-1 xml declaration", contentType: "application/xml", body: "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><patient><name>caf\xe9</name></patient>", want: "café"},
+		{name: "utf-16 json", contentType: "application/json; charset=utf-16le", body: "{\x00\"\x00n\x00a\x00m\x00e\x00\"\x00:\x00\"\x00c\x00a\x00f\x00\xe9\x00\"\x00}\x00", want: "café"},
+		{name: "unsupported type", contentType: "text/plain", body: "café", wantErr: ErrUnsupportedMediaType},
+		{name: "html", contentType: "text/html", body: "<p>café</p>", wantErr: ErrUnsupportedMediaType},
+		{name: "unparseable type", contentType: "application/json; charset", body: `{}`, wantErr: ErrUnsupportedMediaType},
+		{name: "unknown charset", contentType: "application/json; charset=klingon", body: `{}`, wantErr: ErrUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+			var got decodePatient
+			err := Decode(r, &got)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("err = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != test.want {
+				t.Errorf("name = %q, want %q", got.Name, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	serve := func(method, contentType, body string) (*httptest.ResponseRecorder, bool) {
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		var patient decodePatient
+		return rec, DecodeRequest(rec, r, &patient)
+	}
+
+	if rec, ok := serve("POST", "application/json", `{"name":"Ada"}`); !ok || rec.Body.Len() != 0 {
+		t.Errorf("valid body: ok = %v, body = %s", ok, rec.Body)
+	}
+
+	rec, ok := serve("POST", "text/plain", "Ada")
+	if ok || rec.Code != basehttp.StatusUnsupportedMediaType {
+		t.Errorf("POST text/plain: ok = %v, status = %d, want 415", ok, rec.Code)
+	}
+	if got := rec.Header().Get("Accept-Post"); got != decodableTypes {
+		t.Errorf("Accept-Post = %q, want %q", got, decodableTypes)
+	}
+
+	rec, _ = serve("PATCH", "text/plain", "Ada")
+	if got := rec.Header().Get("Accept-Patch"); rec.Code != basehttp.StatusUnsupportedMediaType || got != decodableTypes {
+		t.Errorf("PATCH text/plain: status = %d, Accept-Patch = %q", rec.Code, got)
+	}
+
+	rec, ok = serve("PUT", "application/json", `{"name":`)
+	if ok || rec.Code != basehttp.StatusBadRequest {
+		t.Errorf("malformed JSON: ok = %v, status = %d, want 400", ok, rec.Code)
+	}
+	if rec.Header().Get("Accept-Post") != "" || rec.Header().Get("Accept-Patch") != "" {
+		t.Error("malformed JSON shouldn't list the accepted types")
+	}
+}
+
+func TestDecideAccept(t *testing.T) {
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	tests := map[string]string{
+		"":                           "application/json",
+		"*/*":                        "application/json",
+		"application/json":           "application/json",
+		"application/xml":            "application/xml",
+		"application/cbor":           "application/cbor",
+		"text/csv; header=present":   ContentTypeCSV,
+		"application/json+fhir":      ContentTypeFHIRJSON,
+		"text/xml":                   "application/json",
+		"application/xhtml+xml":      "application/json",
+		"application/problem+json":   "application/json",
+		"text/html":                  "application/json", // no templates
+		browser:                      "application/json",
+		"application/xml, text/html": "application/json",
+	}
+	for accept, want := range tests {
+		if got := decideAccept(basehttp.Header{"Accept": {accept}}); got != want {
+			t.Errorf("decideAccept(%q) = %q, want %q", accept, got, want)
+		}
+	}
+
+}
@@ -0,0 +1,220 @@
+package http
+
+import (
+	nh "net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ugorji/go/codec"
+)
+
+// PrettyParam is the query parameter that indents JSON and XML responses, for
+// debugging, e.g. ?pretty or ?pretty=true. ?pretty=false turns off an Indent
+// set in the EncodeOptions.
+const PrettyParam = "pretty"
+
+// NamingPolicy decides the names of fields that don't have one in their json tag
+type NamingPolicy int
+
+const (
+	// NamingGo uses the Go field name, e.g. PatientID
+	NamingGo NamingPolicy = iota
+	// NamingSnakeCase turns PatientID into patient_id
+	NamingSnakeCase
+	// NamingCamelCase turns PatientID into patientId
+	NamingCamelCase
+)
+
+// EncodeOptions configures how JSON and CBOR responses are encoded. The zero
+// value is how responses have always been sent.
+type EncodeOptions struct {
+	// Naming names the fields without a name in their json tag
+	Naming NamingPolicy
+
+	// OmitEmpty leaves out fields with the zero value, as if every field's
+	// json tag had omitempty
+	OmitEmpty bool
+
+	// TimeLayout is the time.Format layout times are sent in, e.g.
+	// time.RFC3339. If empty JSON uses RFC 3339 with nanoseconds and CBOR
+	// uses its own time type.
+	TimeLayout string
+
+	// HTMLCharsAsIs writes <, > and & in JSON strings as they are, instead of
+	// escaping them as \u003c etc.
+	HTMLCharsAsIs bool
+
+	// Indent is the number of spaces to indent JSON and XML by, or tabs if
+	// negative. See PrettyParam.
+	Indent int
+
+	// root names the XML root element after the response's type, which
+	// shaping can replace with one that has no name
+	root string
+}
+
+// SetEncodeOptions sets the options Respond, RespondError, RespondCached and
+// RespondStream encode with.
+// Naming, OmitEmpty and TimeLayout don't change RespondError's ResponseError,
+// so other services can always parse it.
+func SetEncodeOptions(opts EncodeOptions) {
+	encodeOptions.Lock()
+	defer encodeOptions.Unlock()
+	encodeOptions.opts = opts
+}
+
+// DefaultEncodeOptions returns the options set with SetEncodeOptions, to start
+// from when a handler needs different ones, e.g.
+//
+//	opts := http.DefaultEncodeOptions()
+//	opts.Naming = http.NamingCamelCase
+//	http.RespondWithOptions(w, r, nh.StatusOK, legacy, opts)
+func DefaultEncodeOptions() EncodeOptions {
+	encodeOptions.RLock()
+	defer encodeOptions.RUnlock()
+	return encodeOptions.opts
+}
+
+// RespondWithOptions is Respond encoding with opts instead of the options set
+// with SetEncodeOptions
+func RespondWithOptions(w nh.ResponseWriter, r *nh.Request, statusCode int, response interface{}, opts EncodeOptions) {
+	respond(w, r, statusCode, "", response, opts)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+var encodeOptions struct {
+	sync.RWMutex
+	opts EncodeOptions
+}
+
+// requestEncodeOptions applies the PrettyParam of a request to opts
+func requestEncodeOptions(r *nh.Request, opts EncodeOptions) EncodeOptions {
+	values, ok := r.URL.Query()[PrettyParam]
+	if !ok {
+		return opts
+	}
+	pretty := values[0] == ""
+	if !pretty {
+		pretty, _ = strconv.ParseBool(values[0])
+	}
+	switch {
+	case !pretty:
+		opts.Indent = 0
+	case opts.Indent == 0:
+		opts.Indent = 2
+	}
+	return opts
+}
+
+// codecContentType reports whether a content type is encoded with the codec
+// package, so the EncodeOptions apply to it
+func codecContentType(contentType string) bool {
+	switch contentType {
+	case "application/json", ContentTypeFHIRJSON, "application/cbor",
+		ContentTypeNDJSON, ContentTypeJSONSeq, ContentTypeCBORSeq, ContentTypeEventStream:
+		return true
+	}
+	return false
+}
+
+// handleKey is the part of the EncodeOptions that the handles depend on
+type handleKey struct {
+	indent        int8
+	htmlCharsAsIs bool
+}
+
+// jsonCodecs are built once for each handleKey, the codec package caches
+// what it learns about types in the handle
+var jsonCodecs sync.Map
+
+// jsonCodec returns the encoders for JSON with opts
+func jsonCodec(opts EncodeOptions) *codecPool {
+	indent := opts.Indent
+	if indent > 16 {
+		indent = 16
+	} else if indent < -16 {
+		indent = -16
+	}
+	key := handleKey{indent: int8(indent), htmlCharsAsIs: opts.HTMLCharsAsIs}
+	if p, ok := jsonCodecs.Load(key); ok {
+		return p.(*codecPool)
+	}
+	json := &codec.JsonHandle{}
+	json.Canonical = true
+	json.Indent = key.indent
+	json.HTMLCharsAsIs = key.htmlCharsAsIs
+	p, _ := jsonCodecs.LoadOrStore(key, newCodecPool(json))
+	return p.(*codecPool)
+}
+
+// cborCodec is shared as none of the EncodeOptions change CBOR
+var cborCodec = newCodecPool(&codec.CborHandle{})
+
+// xmlIndent is the indent for the XML encoder
+func xmlIndent(opts EncodeOptions) string {
+	if opts.Indent < 0 {
+		return strings.Repeat("\t", -opts.Indent)
+	}
+	return strings.Repeat(" ", opts.Indent)
+}
+
+// fieldName applies a naming policy to a Go field name
+func fieldName(name string, naming NamingPolicy) string {
+	if naming == NamingGo {
+		return name
+	}
+	words := splitWords(name)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if naming == NamingCamelCase && i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+	if naming == NamingCamelCase {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words, "_")
+}
+
+// splitWords splits a Go name into words, keeping initialisms together,
+// e.g. HTTPServerID is HTTP, Server, ID
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		switch {
+		case unicode.IsLower(prev) && unicode.IsUpper(cur),
+			unicode.IsUpper(prev) && unicode.IsUpper(cur) && unicode.IsLower(next),
+			prev == '_':
+			if word := strings.Trim(string(runes[start:i]), "_"); word != "" {
+				words = append(words, word)
+			}
+			start = i
+		}
+	}
+	if word := strings.Trim(string(runes[start:]), "_"); word != "" {
+		words = append(words, word)
+	}
+	return words
+}
+
+// setTag returns tag with the value for key replaced or added
+func setTag(tag reflect.StructTag, key, value string) reflect.StructTag {
+	entry := key + ":" + strconv.Quote(value)
+	if old, ok := tag.Lookup(key); ok {
+		return reflect.StructTag(strings.Replace(string(tag), key+":"+strconv.Quote(old), entry, 1))
+	}
+	return reflect.StructTag(strings.TrimSpace(string(tag) + " " + entry))
+}
@@ -0,0 +1,180 @@
+package http
+
+import (
+	nh "net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFieldName(t *testing.T) {
+	tests := []struct {
+		name  string
+		snake string
+		camel string
+	}{
+		{"PatientID", "patient_id", "patientId"},
+		{"HTTPServerID", "http_server_id", "httpServerId"},
+		{"Name", "name", "name"},
+		{"URL", "url", "url"},
+		{"DateOfBirth", "date_of_birth", "dateOfBirth"},
+		{"Address_Line1", "address_line1", "addressLine1"},
+	}
+	for _, tt := range tests {
+		if got := fieldName(tt.name, NamingGo); got != tt.name {
+			t.Errorf("fieldName(%q, NamingGo) = %q", tt.name, got)
+		}
+		if got := fieldName(tt.name, NamingSnakeCase); got != tt.snake {
+			t.Errorf("fieldName(%q, NamingSnakeCase) = %q, want %q", tt.name, got, tt.snake)
+		}
+		if got := fieldName(tt.name, NamingCamelCase); got != tt.camel {
+			t.Errorf("fieldName(%q, NamingCamelCase) = %q, want %q", tt.name, got, tt.camel)
+		}
+	}
+}
+
+func TestSetTag(t *testing.T) {
+	tests := []struct {
+		tag  reflect.StructTag
+		want reflect.StructTag
+	}{
+		{``, `json:"id"`},
+		{`xml:"id"`, `xml:"id" json:"id"`},
+		{`json:"old,omitempty" xml:"id"`, `json:"id" xml:"id"`},
+	}
+	for _, tt := range tests {
+		if got := setTag(tt.tag, "json", "id"); got != tt.want {
+			t.Errorf("setTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestRequestEncodeOptions(t *testing.T) {
+	tests := []struct {
+		query  string
+		indent int
+		want   int
+	}{
+		{"", 0, 0},
+		{"", 4, 4},
+		{"pretty", 0, 2},
+		{"pretty=true", 0, 2},
+		{"pretty=1", -1, -1},
+		{"pretty=false", 4, 0},
+		{"pretty=nonsense", 4, 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/?"+tt.query, nil)
+		if got := requestEncodeOptions(r, EncodeOptions{Indent: tt.indent}).Indent; got != tt.want {
+			t.Errorf("%q with Indent %d = %d, want %d", tt.query, tt.indent, got, tt.want)
+		}
+	}
+}
+
+type encodeOptionsPatient struct {
+	PatientID   string
+	FullName    string `json:"name"`
+	DateOfBirth time.Time
+	Notes       string
+	Count       int `json:"count,omitempty"`
+}
+
+func TestRespondWithOptions(t *testing.T) {
+	patient := encodeOptionsPatient{
+		PatientID:   "p1",
+		FullName:    "Jane <Doe>",
+		DateOfBirth: time.Date(1980, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name   string
+		opts   EncodeOptions
+		accept string
+		query  string
+		want   string
+	}{
+		{
+			name: "zero value",
+			want: `{"DateOfBirth":"1980-02-01T00:00:00Z","Notes":"","PatientID":"p1","name":"Jane \u003cDoe\u003e"}`,
+		},
+		{
+			name: "snake case",
+			opts: EncodeOptions{Naming: NamingSnakeCase},
+			want: `{"date_of_birth":"1980-02-01T00:00:00Z","name":"Jane \u003cDoe\u003e","notes":"","patient_id":"p1"}`,
+		},
+		{
+			name: "camel case and omit empty",
+			opts: EncodeOptions{Naming: NamingCamelCase, OmitEmpty: true},
+			want: `{"dateOfBirth":"1980-02-01T00:00:00Z","name":"Jane \u003cDoe\u003e","patientId":"p1"}`,
+		},
+		{
+			name: "time layout",
+			opts: EncodeOptions{TimeLayout: "2006-01-02", OmitEmpty: true},
+			want: `{"DateOfBirth":"1980-02-01","PatientID":"p1","name":"Jane \u003cDoe\u003e"}`,
+		},
+		{
+			name: "html chars as is",
+			opts: EncodeOptions{HTMLCharsAsIs: true, OmitEmpty: true},
+			want: `{"DateOfBirth":"1980-02-01T00:00:00Z","PatientID":"p1","name":"Jane <Doe>"}`,
+		},
+		{
+			name:  "pretty",
+			opts:  EncodeOptions{OmitEmpty: true, HTMLCharsAsIs: true},
+			query: "pretty",
+			want:  "{\n  \"DateOfBirth\": \"1980-02-01T00:00:00Z\",\n  \"PatientID\": \"p1\",\n  \"name\": \"Jane <Doe>\"\n}",
+		},
+		{
+			name:   "xml ignores naming and omit empty",
+			opts:   EncodeOptions{Naming: NamingSnakeCase, OmitEmpty: true},
+			accept: "application/xml",
+			want:   `<?xml version="1.0" encoding="UTF-8"?><encodeOptionsPatient><PatientID>p1</PatientID><name>Jane &lt;Doe&gt;</name><DateOfBirth>1980-02-01T00:00:00Z</DateOfBirth><Notes></Notes></encodeOptionsPatient>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			RespondWithOptions(w, r, nh.StatusOK, patient, tt.opts)
+			if got := w.Body.String(); got != tt.want && got != tt.want+"\n" {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetEncodeOptions(t *testing.T) {
+	defer SetEncodeOptions(EncodeOptions{})
+	SetEncodeOptions(EncodeOptions{Naming: NamingSnakeCase, OmitEmpty: true})
+
+	tests := []struct {
+		name    string
+		respond func(w nh.ResponseWriter, r *nh.Request)
+		want    string
+	}{
+		{
+			name: "respond",
+			respond: func(w nh.ResponseWriter, r *nh.Request) {
+				Respond(w, r, nh.StatusOK, encodeOptionsPatient{PatientID: "p1"})
+			},
+			want: `{"patient_id":"p1"}`,
+		},
+		{
+			name:    "errors keep their shape",
+			respond: func(w nh.ResponseWriter, r *nh.Request) { RespondError(w, r, nh.StatusNotFound) },
+			want:    `{"code":"not_found","documentation":"","message":"Not Found","status_code":404}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.respond(w, httptest.NewRequest("GET", "/", nil))
+			if got := w.Body.String(); got != tt.want && got != tt.want+"\n" {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"encoding/xml"
+	nh "net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DocHQ/helpers/requestid"
+)
+
+// EnvelopeHeader and EnvelopeParam let a client turn the envelope on or off
+// for a request, e.g. X-Envelope: true or ?envelope=false
+const (
+	EnvelopeHeader = "X-Envelope"
+	EnvelopeParam  = "envelope"
+)
+
+// Envelope is the body sent instead of the bare payload in envelope mode.
+// Success responses fill Data, errors fill Errors.
+type Envelope struct {
+	XMLName xml.Name        `json:"-" codec:"-" xml:"response"`
+	Data    interface{}     `json:"data" xml:"data,omitempty"`
+	Meta    EnvelopeMeta    `json:"meta,omitempty" xml:"meta,omitempty"`
+	Errors  []ResponseError `json:"errors,omitempty" xml:"error,omitempty"`
+}
+
+// EnvelopeMeta holds the meta section of an Envelope. The request id, the
+// time taken and a Page's paging are added automatically.
+type EnvelopeMeta map[string]interface{}
+
+// EnvelopeMetaFunc adds to the meta of every envelope, e.g. the API version
+type EnvelopeMetaFunc func(r *nh.Request, meta EnvelopeMeta)
+
+// WithEnvelope wraps every JSON, CBOR and XML response from the Router in an
+// Envelope, unless the client turns it off with EnvelopeHeader or
+// EnvelopeParam. Without this option clients can still turn it on.
+// HTML, CSV, plain text, FHIR and streamed responses are never wrapped.
+func WithEnvelope() Option {
+	return func(r *Router) {
+		r.Use(func(next nh.Handler) nh.Handler {
+			return nh.HandlerFunc(func(w nh.ResponseWriter, r *nh.Request) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), envelopeKey{}, true)))
+			})
+		})
+	}
+}
+
+// RegisterEnvelopeMeta adds a function that is called to fill in the meta of
+// every envelope
+func RegisterEnvelopeMeta(fn EnvelopeMetaFunc) {
+	envelopeMetaFuncs.Lock()
+	defer envelopeMetaFuncs.Unlock()
+	envelopeMetaFuncs.funcs = append(envelopeMetaFuncs.funcs, fn)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+type envelopeKey struct{}
+
+var envelopeMetaFuncs struct {
+	sync.RWMutex
+	funcs []EnvelopeMetaFunc
+}
+
+// useEnvelope decides whether a response in contentType is wrapped, the
+// client's header or query parameter wins over the Router's default
+func useEnvelope(header nh.Header, r *nh.Request, contentType string) bool {
+	switch contentType {
+	case "application/json", "application/cbor", "application/xml":
+	default:
+		return false
+	}
+
+	addVary(header, EnvelopeHeader)
+
+	for _, flag := range []string{r.Header.Get(EnvelopeHeader), r.URL.Query().Get(EnvelopeParam)} {
+		if flag == "" {
+			continue
+		}
+		if on, err := strconv.ParseBool(flag); err == nil {
+			return on
+		}
+	}
+	on, _ := r.Context().Value(envelopeKey{}).(bool)
+	return on
+}
+
+// envelope wraps a success response, moving a Page's paging into the meta
+func envelope(r *nh.Request, response interface{}) Envelope {
+	meta := envelopeMeta(r)
+	if page, ok := response.(Page); ok {
+		meta["paging"] = page.Paging
+		response = page.Items
+	}
+	return Envelope{Data: response, Meta: meta}
+}
+
+// errorEnvelope wraps a RespondError response
+func errorEnvelope(r *nh.Request, response ResponseError) Envelope {
+	return Envelope{Meta: envelopeMeta(r), Errors: []ResponseError{response}}
+}
+
+func envelopeMeta(r *nh.Request) EnvelopeMeta {
+	meta := EnvelopeMeta{}
+	if id := requestid.FromContext(r.Context()); id != "" {
+		meta["request_id"] = id
+	}
+	if state, ok := r.Context().Value(accessLogStateKey{}).(*accessLogState); ok && !state.start.IsZero() {
+		meta["duration_ms"] = float64(time.Since(state.start)) / float64(time.Millisecond)
+	}
+
+	envelopeMetaFuncs.RLock()
+	funcs := envelopeMetaFuncs.funcs
+	envelopeMetaFuncs.RUnlock()
+	for _, fn := range funcs {
+		fn(r, meta)
+	}
+	return meta
+}
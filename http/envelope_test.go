@@ -0,0 +1,118 @@
+package http
+
+import (
+	"encoding/json"
+	nh "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnvelopeToggle(t *testing.T) {
+	captureAccessLog(t)
+
+	plain := New()
+	wrapped := New(WithEnvelope())
+	for _, router := range []*Router{plain, wrapped} {
+		router.HandleFunc("/patients", func(w nh.ResponseWriter, r *nh.Request) {
+			Respond(w, r, nh.StatusOK, map[string]string{"name": "Jane"})
+		})
+	}
+
+	tests := []struct {
+		name   string
+		router *Router
+		query  string
+		header string
+		accept string
+		want   bool
+	}{
+		{"off by default", plain, "", "", "", false},
+		{"on for the router", wrapped, "", "", "", true},
+		{"header turns it on", plain, "", "true", "", true},
+		{"param turns it on", plain, "envelope=1", "", "", true},
+		{"header turns it off", wrapped, "", "false", "", false},
+		{"param turns it off", wrapped, "envelope=false", "", "", false},
+		{"header wins over param", plain, "envelope=false", "true", "", true},
+		{"invalid header is ignored", wrapped, "envelope=false", "maybe", "", false},
+		{"never for plain text", wrapped, "", "", "text/plain", false},
+		{"never for CSV", wrapped, "", "", "text/csv", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/patients?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set(EnvelopeHeader, tt.header)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			tt.router.ServeHTTP(w, r)
+
+			var body map[string]json.RawMessage
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if _, got := body["data"]; got != tt.want {
+				t.Errorf("wrapped = %v, want %v: %s", got, tt.want, w.Body.String())
+			}
+			if tt.accept == "" && !containsString(w.Header().Values("Vary"), EnvelopeHeader) {
+				t.Errorf("Vary = %q, want %s", w.Header().Values("Vary"), EnvelopeHeader)
+			}
+		})
+	}
+}
+
+func TestEnvelopeMeta(t *testing.T) {
+	captureAccessLog(t)
+	defer func() {
+		envelopeMetaFuncs.Lock()
+		envelopeMetaFuncs.funcs = nil
+		envelopeMetaFuncs.Unlock()
+	}()
+	RegisterEnvelopeMeta(func(r *nh.Request, meta EnvelopeMeta) {
+		meta["api_version"] = "v2"
+	})
+
+	router := New(WithEnvelope())
+	router.HandleFunc("/patients", func(w nh.ResponseWriter, r *nh.Request) {
+		Respond(w, r, nh.StatusOK, Page{Items: []string{"a"}, Paging: PageInfo{Limit: 1, Total: 3}})
+	})
+	router.HandleFunc("/missing", func(w nh.ResponseWriter, r *nh.Request) {
+		RespondError(w, r, nh.StatusNotFound, "No such patient")
+	})
+
+	tests := []struct {
+		path       string
+		wantData   string
+		wantErrors string
+		wantPaging bool
+	}{
+		{"/patients", `["a"]`, "", true},
+		{"/missing", "null", `[{"code":"not_found","documentation":"","message":"No such patient","request_id":"abc-123","status_code":404}]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("X-Request-ID", "abc-123")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			var body struct {
+				Data   json.RawMessage            `json:"data"`
+				Meta   map[string]json.RawMessage `json:"meta"`
+				Errors json.RawMessage            `json:"errors"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if string(body.Data) != tt.wantData || string(body.Errors) != tt.wantErrors {
+				t.Errorf("body = %s", w.Body.String())
+			}
+			if string(body.Meta["request_id"]) != `"abc-123"` || string(body.Meta["api_version"]) != `"v2"` || body.Meta["duration_ms"] == nil {
+				t.Errorf("meta = %s", w.Body.String())
+			}
+			if _, ok := body.Meta["paging"]; ok != tt.wantPaging {
+				t.Errorf("paging in meta = %v, want %v", ok, tt.wantPaging)
+			}
+		})
+	}
+}
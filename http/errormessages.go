@@ -0,0 +1,148 @@
+package http
+
+import (
+	"fmt"
+	nh "net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+// ErrorCode is a RespondError detail parameter that sets the machine readable
+// Code of the error, and translates the Message if a catalogue has the code.
+// Without one the code comes from the status, e.g. "not_found".
+//
+//	http.RespondError(w, r, nh.StatusNotFound, http.ErrorCode("patient_not_found"), "No such patient")
+type ErrorCode string
+
+// ErrorDetail is a RespondError detail parameter for a Details entry that can be
+// translated. The message for Code is used as a fmt format for Args, falling
+// back to Default if no catalogue has the code.
+//
+//	http.ErrorDetail{Code: "field_required", Default: "%s is required", Args: []interface{}{"email"}}
+type ErrorDetail struct {
+	Code    string
+	Default string
+	Args    []interface{}
+}
+
+// RegisterErrorMessages adds translations keyed by error code for a language
+// (a BCP 47 tag such as "fr" or "pt-BR"), merging with any already registered.
+// RespondError picks the language from the Accept-Language header. The codes
+// of the standard statuses are their text in snake case, e.g. "not_found".
+//
+//	http.RegisterErrorMessages("fr", map[string]string{
+//		"not_found":      "Introuvable",
+//		"field_required": "%s est obligatoire",
+//	})
+func RegisterErrorMessages(lang string, messages map[string]string) error {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return err
+	}
+
+	errorCatalogues.Lock()
+	defer errorCatalogues.Unlock()
+	catalogue, ok := errorCatalogues.byTag[tag]
+	if !ok {
+		catalogue = map[string]string{}
+		errorCatalogues.byTag[tag] = catalogue
+		errorCatalogues.tags = append(errorCatalogues.tags, tag)
+		errorCatalogues.matcher = language.NewMatcher(errorCatalogues.tags)
+	}
+	for code, message := range messages {
+		catalogue[code] = message
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// errorCatalogues holds the translations. English is always first so it is
+// what the matcher falls back to, with nh.StatusText behind it.
+var errorCatalogues = struct {
+	sync.RWMutex
+	tags    []language.Tag
+	byTag   map[language.Tag]map[string]string
+	matcher language.Matcher
+}{
+	tags:    []language.Tag{language.English},
+	byTag:   map[language.Tag]map[string]string{language.English: {}},
+	matcher: language.NewMatcher([]language.Tag{language.English}),
+}
+
+// errorTranslator looks up messages in the language negotiated for a request
+type errorTranslator struct {
+	tag       language.Tag
+	catalogue map[string]string
+	english   map[string]string
+}
+
+func newErrorTranslator(r *nh.Request) errorTranslator {
+	errorCatalogues.RLock()
+	defer errorCatalogues.RUnlock()
+
+	t := errorTranslator{tag: language.English, english: errorCatalogues.byTag[language.English]}
+	if len(errorCatalogues.tags) == 1 {
+		return t
+	}
+	accept, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err == nil && len(accept) > 0 {
+		_, index, confidence := errorCatalogues.matcher.Match(accept...)
+		if confidence != language.No {
+			t.tag = errorCatalogues.tags[index]
+		}
+	}
+	t.catalogue = errorCatalogues.byTag[t.tag]
+	return t
+}
+
+// message returns the translation of code and its language, English if the
+// negotiated language doesn't have it
+func (t errorTranslator) message(code string) (string, language.Tag, bool) {
+	errorCatalogues.RLock()
+	defer errorCatalogues.RUnlock()
+	if message, ok := t.catalogue[code]; ok {
+		return message, t.tag, true
+	}
+	message, ok := t.english[code]
+	return message, language.English, ok
+}
+
+func (t errorTranslator) detail(d ErrorDetail) string {
+	format, _, ok := t.message(d.Code)
+	if !ok {
+		format = d.Default
+	}
+	if len(d.Args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, d.Args...)
+}
+
+// localised reports whether there is more than one language, so responses
+// depend on Accept-Language
+func (t errorTranslator) localised() bool {
+	return t.catalogue != nil
+}
+
+// statusErrorCode turns the text of a status into its error code, e.g. "not_found"
+func statusErrorCode(status int) string {
+	text := nh.StatusText(status)
+	if text == "" {
+		return fmt.Sprintf("status_%d", status)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == ' ' || r == '-':
+			return '_'
+		}
+		return -1
+	}, text)
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"encoding/json"
+	nh "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func resetErrorMessages() {
+	errorCatalogues.Lock()
+	errorCatalogues.tags = []language.Tag{language.English}
+	errorCatalogues.byTag = map[language.Tag]map[string]string{language.English: {}}
+	errorCatalogues.matcher = language.NewMatcher(errorCatalogues.tags)
+	errorCatalogues.Unlock()
+}
+
+func TestRespondErrorMessages(t *testing.T) {
+	defer resetErrorMessages()
+	for lang, messages := range map[string]map[string]string{
+		"en":    {"patient_not_found": "No such patient", "field_required": "%s is required"},
+		"fr":    {"not_found": "Introuvable", "patient_not_found": "Patient introuvable", "field_required": "%s est obligatoire"},
+		"pt-BR": {"not_found": "Não encontrado"},
+	} {
+		if err := RegisterErrorMessages(lang, messages); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	required := ErrorDetail{Code: "field_required", Default: "%s needed", Args: []interface{}{"email"}}
+	unknown := ErrorDetail{Code: "field_unknown", Default: "%s unknown", Args: []interface{}{"email"}}
+
+	tests := []struct {
+		name         string
+		language     string
+		detail       []interface{}
+		wantCode     string
+		wantMessage  string
+		wantDetails  []string
+		wantLanguage string
+	}{
+		{"status text", "", nil, "not_found", "Not Found", nil, "en"},
+		{"translated status", "fr-CA, en;q=0.5", nil, "not_found", "Introuvable", nil, "fr"},
+		{"regional", "pt-BR", nil, "not_found", "Não encontrado", nil, "pt-BR"},
+		{"unknown language", "de", nil, "not_found", "Not Found", nil, "en"},
+		{"handler message beats the status", "fr", []interface{}{"Gone away"}, "not_found", "Gone away", nil, ""},
+		{"code beats the handler message", "fr", []interface{}{ErrorCode("patient_not_found"), "No patient"}, "patient_not_found", "Patient introuvable", nil, "fr"},
+		{"code falls back to English", "pt-BR", []interface{}{ErrorCode("patient_not_found")}, "patient_not_found", "No such patient", nil, "en"},
+		{"code without a message", "fr", []interface{}{ErrorCode("patient_archived"), "Archived"}, "patient_archived", "Archived", nil, ""},
+		{"details", "fr", []interface{}{required, unknown}, "not_found", "Introuvable", []string{"email est obligatoire", "email unknown"}, "fr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.language != "" {
+				r.Header.Set("Accept-Language", tt.language)
+			}
+			w := httptest.NewRecorder()
+			RespondError(w, r, nh.StatusNotFound, tt.detail...)
+
+			var got ResponseError
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Code != tt.wantCode || got.Message != tt.wantMessage {
+				t.Errorf("code, message = %q, %q, want %q, %q", got.Code, got.Message, tt.wantCode, tt.wantMessage)
+			}
+			if len(got.Details) != len(tt.wantDetails) {
+				t.Errorf("details = %q, want %q", got.Details, tt.wantDetails)
+			}
+			for i := range tt.wantDetails {
+				if i < len(got.Details) && got.Details[i] != tt.wantDetails[i] {
+					t.Errorf("details = %q, want %q", got.Details, tt.wantDetails)
+				}
+			}
+			if lang := w.Header().Get("Content-Language"); lang != tt.wantLanguage {
+				t.Errorf("Content-Language = %q, want %q", lang, tt.wantLanguage)
+			}
+			if vary := w.Header().Values("Vary"); !containsString(vary, "Accept-Language") {
+				t.Errorf("Vary = %q, want Accept-Language", vary)
+			}
+		})
+	}
+}
+
+func TestRespondErrorSingleLanguage(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "fr")
+	RespondError(w, r, nh.StatusNotFound)
+
+	if containsString(w.Header().Values("Vary"), "Accept-Language") || w.Header().Get("Content-Language") != "" {
+		t.Errorf("headers = %v, want no language headers with only English", w.Header())
+	}
+}
+
+func TestRegisterErrorMessagesInvalidTag(t *testing.T) {
+	defer resetErrorMessages()
+	if err := RegisterErrorMessages("not a tag!", map[string]string{"x": "y"}); err == nil {
+		t.Error("expected an error for an invalid language tag")
+	}
+}
+
+func TestStatusErrorCode(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{nh.StatusNotFound, "not_found"},
+		{nh.StatusInternalServerError, "internal_server_error"},
+		{nh.StatusNonAuthoritativeInfo, "non_authoritative_information"},
+		{nh.StatusTeapot, "im_a_teapot"},
+		{nh.StatusRequestURITooLong, "request_uri_too_long"},
+		{599, "status_599"},
+	}
+	for _, tt := range tests {
+		if got := statusErrorCode(tt.status); got != tt.want {
+			t.Errorf("statusErrorCode(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"log"
+	nh "net/http"
+	"strings"
+)
+
+// HTMLError is the data error templates are rendered with. The ResponseError
+// fields, including RequestID, can be used directly, e.g. {{.Message}}.
+type HTMLError struct {
+	ResponseError
+
+	// StatusText is the standard text for the status, e.g. "Not Found"
+	StatusText string
+
+	// Path is the path of the request that failed
+	Path string
+}
+
+// RegisterHTMLErrorTemplate makes RespondError render errors with this status
+// using pages from SetHTMLTemplates, textHTML for full pages and
+// applicationHTML for fragments. A statusCode of 0 applies to every status
+// without a page of its own. An empty page name keeps the built in page for
+// that type.
+//
+//	http.RegisterHTMLErrorTemplate(0, "error.html", "")
+//	http.RegisterHTMLErrorTemplate(nh.StatusNotFound, "not-found.html", "")
+//
+// Messages are escaped, use {{nl2br .Message}} to keep line breaks.
+// If a page fails to render the built in page is sent instead.
+func RegisterHTMLErrorTemplate(statusCode int, textHTML, applicationHTML string) {
+	htmlTemplates.Lock()
+	defer htmlTemplates.Unlock()
+	htmlTemplates.errorPages[statusCode] = htmlErrorPages{TextHTML: textHTML, ApplicationHTML: applicationHTML}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+type htmlErrorPages struct {
+	TextHTML        string
+	ApplicationHTML string
+}
+
+func (p htmlErrorPages) page(contentType string) string {
+	if contentType == "application/html" {
+		return p.ApplicationHTML
+	}
+	return p.TextHTML
+}
+
+// htmlErrorTemplateFor returns the registered page for a status, "" for the built in one
+func htmlErrorTemplateFor(statusCode int, contentType string) string {
+	htmlTemplates.RLock()
+	defer htmlTemplates.RUnlock()
+	if page := htmlTemplates.errorPages[statusCode].page(contentType); page != "" {
+		return page
+	}
+	return htmlTemplates.errorPages[0].page(contentType)
+}
+
+// renderHTMLError writes an error page for RespondError.
+// Be careful in here not to recurse (by calling RespondError() again) when there is an error.
+func renderHTMLError(w io.Writer, r *nh.Request, contentType string, response ResponseError) error {
+	data := HTMLError{
+		ResponseError: response,
+		StatusText:    nh.StatusText(response.StatusCode),
+		Path:          r.URL.Path,
+	}
+
+	if page := htmlErrorTemplateFor(response.StatusCode, contentType); page != "" {
+		err := renderHTML(w, page, data)
+		if err == nil {
+			return nil
+		}
+		// renderHTML writes nothing when it fails, so the built in page can still be sent
+		log.Println("[RespondError] Error Template Error:", page, err)
+	}
+
+	if contentType == "application/html" {
+		return errorTemplateApplicationHTML.Execute(w, data)
+	}
+	return errorTemplateTextHTML.Execute(w, data)
+}
+
+// nl2br escapes s and keeps its line breaks
+func nl2br(s string) TrustedHTML {
+	s = strings.Replace(strings.TrimSpace(s), "\r", "", -1)
+	return TrustedHTML(strings.Replace(htmltemplate.HTMLEscapeString(s), "\n", "<br/>", -1))
+}
+
+const sErrorTemplateApplicationHTML = `<table>
+<tr><td>StatusCode:</td><td id="errorcode">{{.StatusCode}}</td></tr>
+<tr><td>Message:</td><td id="errormessage">{{nl2br .Message}}</td></tr>
+<tr><td>Documentation:</td><td id="errordocumentation">{{nl2br .Documentation}}</td></tr>
+{{if .RequestID}}<tr><td>RequestID:</td><td id="errorrequestid">{{.RequestID}}</td></tr>
+{{end}}</table>`
+
+const sErrorTemplateTextHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<title>An error occured</title>
+<style>
+table, tr, td {
+	border: 1px solid black;
+	border-collapse: collapse;
+}
+#errormessage {
+	font-weight: bold;
+}
+</style>
+</head>
+<body>` + sErrorTemplateApplicationHTML + `
+</body>
+</html>
+`
+
+var (
+	errorTemplateApplicationHTML = htmltemplate.Must(htmltemplate.New("ErrorOccured").Funcs(templateFuncs).Parse(sErrorTemplateApplicationHTML))
+	errorTemplateTextHTML        = htmltemplate.Must(htmltemplate.New("ErrorOccured").Funcs(templateFuncs).Parse(sErrorTemplateTextHTML))
+)
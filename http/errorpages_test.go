@@ -0,0 +1,76 @@
+package http
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DocHQ/helpers/requestid"
+)
+
+func TestHTMLErrorPages(t *testing.T) {
+	defer resetHTMLTemplates()
+	fsys := fstest.MapFS{
+		"error.html":     {Data: []byte(`<p>{{.StatusCode}} {{.StatusText}} {{.Path}}: {{nl2br .Message}} {{.RequestID}}</p>`)},
+		"not-found.html": {Data: []byte(`<h1>Nothing at {{.Path}}</h1>`)},
+		"fragment.html":  {Data: []byte(`<span>{{.Message}}</span>`)},
+		"broken.html":    {Data: []byte(`{{.NoSuchField}}`)},
+		"page.html":      {Data: []byte(`{{.}}`)},
+	}
+	// HTML is only chosen for errors when a success response could be HTML too
+	if err := SetHTMLTemplates(HTMLTemplateOptions{FS: fsys, Pages: []string{"*.html"}, TextHTML: "page.html", ApplicationHTML: "page.html"}); err != nil {
+		t.Fatal(err)
+	}
+	RegisterHTMLErrorTemplate(0, "error.html", "")
+	RegisterHTMLErrorTemplate(404, "not-found.html", "fragment.html")
+	RegisterHTMLErrorTemplate(409, "broken.html", "")
+
+	tests := []struct {
+		name    string
+		accept  string
+		status  int
+		message string
+		want    string
+	}{
+		{"fallback page", "text/html", 400, "Line one\n<b>two</b>", `<p>400 Bad Request /patients/42: Line one<br/>&lt;b&gt;two&lt;/b&gt; abc-123</p>`},
+		{"status page", "text/html", 404, "gone", `<h1>Nothing at /patients/42</h1>`},
+		{"fragment", "application/html", 404, "gone", `<span>gone</span>`},
+		{"built in fragment", "application/html", 400, "bad", `<td id="errormessage">bad</td>`},
+		{"broken page", "text/html", 409, "conflict", `<td id="errormessage">conflict</td>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/patients/42", nil)
+			r = r.WithContext(requestid.NewContext(r.Context(), "abc-123"))
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			RespondError(w, r, tt.status, tt.message)
+
+			if w.Code != tt.status {
+				t.Errorf("status code = %d, want %d", w.Code, tt.status)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("body = %s\nwant %s", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltInHTMLErrorPage(t *testing.T) {
+	defer resetHTMLTemplates()
+	fsys := fstest.MapFS{"page.html": {Data: []byte(`{{.}}`)}}
+	if err := SetHTMLTemplates(HTMLTemplateOptions{FS: fsys, Pages: []string{"*.html"}, TextHTML: "page.html"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	RespondError(w, r, 500, "<script>alert(1)</script>")
+
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "<!DOCTYPE html>") || strings.Contains(body, "<script>") {
+		t.Errorf("body = %s", body)
+	}
+}
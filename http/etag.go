@@ -0,0 +1,193 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	nh "net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheOptions controls the validators RespondCached sends. With no ETag and
+// no LastModified the ETag is computed from the encoded response.
+type CacheOptions struct {
+	// ETag is the version of the resource, e.g. a revision number or hash
+	// kept by the caller. It is quoted if it isn't already.
+	ETag string
+
+	// Weak marks the ETag as weak, i.e. equivalent but not byte for byte
+	// identical representations share it
+	Weak bool
+
+	// LastModified is when the resource last changed, sent as Last-Modified
+	// and compared with If-Modified-Since
+	LastModified time.Time
+
+	// CacheControl is sent as the Cache-Control header if set, e.g. "no-cache"
+	// to make clients revalidate every time
+	CacheControl string
+}
+
+// RespondCached is Respond for resources that support conditional requests.
+// It sends an ETag and/or Last-Modified with the response and, for GET and
+// HEAD requests whose If-None-Match or If-Modified-Since show the client
+// already has this version, replies 304 Not Modified with no body.
+//
+// When the ETag is computed the response is encoded into a buffer first, so
+// the body isn't streamed and encoding errors can still become a 500.
+func RespondCached(w nh.ResponseWriter, r *nh.Request, statusCode int, response interface{}, opts CacheOptions) {
+	contentType, page := negotiateResponse(r, "", response) // request accept is response content-type
+	encodeOpts := requestEncodeOptions(r, DefaultEncodeOptions())
+	encodeOpts.root = xmlTypeName(reflect.TypeOf(response))
+
+	var body *bytes.Buffer
+	etag := ""
+	if opts.ETag != "" {
+		etag = FormatETag(opts.ETag, opts.Weak)
+	} else if opts.LastModified.IsZero() {
+		body = getBuffer()
+		defer putBuffer(body)
+		if err := encodeResponse(body, contentType, page, shapeResponse(r, contentType, response, encodeOpts), encodeOpts); err != nil {
+			log.Println("[RespondCached] Encode Error:", contentType, err)
+			RespondError(w, r, nh.StatusInternalServerError)
+			return
+		}
+		// Each content type is a different representation so needs its own tag
+		representation := contentType
+		if useEnvelope(w.Header(), r, contentType) {
+			representation += ";envelope"
+		}
+		sum := sha256.Sum256(append([]byte(representation+"\n"), body.Bytes()...))
+		etag = FormatETag(hex.EncodeToString(sum[:16]), opts.Weak)
+
+		// The tag is of the payload, so the envelope's meta (e.g. timing)
+		// doesn't change it
+		if representation != contentType {
+			body.Reset()
+			if err := encodeResponse(body, contentType, page, envelope(r, shapeResponse(r, contentType, response, encodeOpts)), encodeOpts); err != nil {
+				log.Println("[RespondCached] Encode Error:", contentType, err)
+				RespondError(w, r, nh.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	header := w.Header()
+	addVary(header, "Accept")
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !opts.LastModified.IsZero() {
+		header.Set("Last-Modified", opts.LastModified.UTC().Format(nh.TimeFormat))
+	}
+	if opts.CacheControl != "" {
+		header.Set("Cache-Control", opts.CacheControl)
+	}
+
+	if statusCode >= 200 && statusCode < 300 && notModified(r, etag, opts.LastModified) {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		w.WriteHeader(nh.StatusNotModified)
+		return
+	}
+
+	if body == nil {
+		Respond(w, r, statusCode, response)
+		return
+	}
+
+	header.Set("Content-Type", contentTypeHeader(contentType))
+	setContentDisposition(header, r, contentType)
+	header.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(statusCode)
+	if r.Method != nh.MethodHead {
+		w.Write(body.Bytes())
+	}
+}
+
+// CheckPreconditions evaluates If-Match and If-Unmodified-Since against the
+// current version of a resource before it is changed. If they fail it replies
+// 412 Precondition Failed through RespondError and returns false, the handler
+// should then return without making the change.
+//
+//	if !http.CheckPreconditions(w, r, http.FormatETag(patient.Version, false), patient.Updated) {
+//		return
+//	}
+//
+// Pass an empty etag if the resource doesn't exist, so If-Match: * fails.
+func CheckPreconditions(w nh.ResponseWriter, r *nh.Request, etag string, lastModified time.Time) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, false) {
+			RespondError(w, r, nh.StatusPreconditionFailed, "The resource has been changed, fetch it again before updating")
+			return false
+		}
+		return true
+	}
+
+	if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !lastModified.IsZero() {
+		if t, err := nh.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			RespondError(w, r, nh.StatusPreconditionFailed, "The resource has been changed, fetch it again before updating")
+			return false
+		}
+	}
+
+	return true
+}
+
+// FormatETag quotes a version as an entity tag, adding the W/ prefix if weak.
+// Values that are already quoted tags are returned as they are.
+func FormatETag(version string, weak bool) string {
+	if strings.HasPrefix(version, `"`) || strings.HasPrefix(version, `W/"`) {
+		return version
+	}
+	tag := strconv.Quote(version)
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// notModified applies If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, for GET and HEAD requests
+func notModified(r *nh.Request, etag string, lastModified time.Time) bool {
+	if r.Method != nh.MethodGet && r.Method != nh.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag, true)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := nh.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagListMatches reports whether etag is in a comma separated list of entity
+// tags (or the list is *). If-None-Match uses weak comparison, If-Match strong.
+func etagListMatches(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,403 @@
+package http
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	nh "net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FHIR media types. When one of these is negotiated the response carries it
+// (with a fhirVersion parameter) rather than plain JSON or XML, and errors are
+// sent as an OperationOutcome resource.
+// FHIR XML is built from the json tags, so resources should be structs, map
+// keys are sorted and lose the element order FHIR requires.
+const (
+	ContentTypeFHIRJSON = "application/fhir+json"
+	ContentTypeFHIRXML  = "application/fhir+xml"
+)
+
+// FHIRNamespace is the XML namespace of FHIR resources
+const FHIRNamespace = "http://hl7.org/fhir"
+
+// FHIRVersion is sent as the fhirVersion parameter of FHIR content types,
+// 4.0 is R4
+var FHIRVersion = "4.0"
+
+// OperationOutcome is the FHIR resource RespondError sends when a FHIR type was
+// negotiated
+type OperationOutcome struct {
+	ResourceType string                  `json:"resourceType"`
+	Issue        []OperationOutcomeIssue `json:"issue"`
+}
+
+// OperationOutcomeIssue is one problem reported in an OperationOutcome
+type OperationOutcomeIssue struct {
+	Severity    string           `json:"severity"`
+	Code        string           `json:"code"`
+	Details     *CodeableConcept `json:"details,omitempty"`
+	Diagnostics string           `json:"diagnostics,omitempty"`
+}
+
+// CodeableConcept is the FHIR data type, only the text is used here
+type CodeableConcept struct {
+	Text string `json:"text,omitempty"`
+}
+
+// NewOperationOutcome converts a ResponseError into an OperationOutcome, with
+// the message as the first issue and each of the details as another
+func NewOperationOutcome(response ResponseError) OperationOutcome {
+	severity, code := "error", fhirIssueCode(response.StatusCode)
+	if response.StatusCode >= 500 {
+		severity = "fatal"
+	}
+
+	outcome := OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue: []OperationOutcomeIssue{{
+			Severity:    severity,
+			Code:        code,
+			Details:     &CodeableConcept{Text: response.Message},
+			Diagnostics: response.Documentation,
+		}},
+	}
+	for _, detail := range response.Details {
+		outcome.Issue = append(outcome.Issue, OperationOutcomeIssue{
+			Severity:    severity,
+			Code:        code,
+			Diagnostics: detail,
+		})
+	}
+	return outcome
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// contentTypeHeader is the Content-Type header for a negotiated content type
+func contentTypeHeader(contentType string) string {
+	if isFHIR(contentType) && FHIRVersion != "" {
+		return contentType + "; fhirVersion=" + FHIRVersion
+	}
+	return contentType
+}
+
+func isFHIR(contentType string) bool {
+	return contentType == ContentTypeFHIRJSON || contentType == ContentTypeFHIRXML
+}
+
+// fhirIssueCode maps an HTTP status to the FHIR issue-type value set
+func fhirIssueCode(statusCode int) string {
+	switch statusCode {
+	case nh.StatusBadRequest, nh.StatusUnsupportedMediaType, nh.StatusNotAcceptable:
+		return "invalid"
+	case nh.StatusUnauthorized:
+		return "login"
+	case nh.StatusForbidden:
+		return "forbidden"
+	case nh.StatusNotFound:
+		return "not-found"
+	case nh.StatusMethodNotAllowed, nh.StatusNotImplemented:
+		return "not-supported"
+	case nh.StatusConflict, nh.StatusPreconditionFailed:
+		return "conflict"
+	case nh.StatusGone:
+		return "deleted"
+	case nh.StatusRequestEntityTooLarge:
+		return "too-costly"
+	case nh.StatusTooManyRequests:
+		return "throttled"
+	case nh.StatusRequestTimeout, nh.StatusGatewayTimeout:
+		return "timeout"
+	case nh.StatusUnprocessableEntity:
+		return "processing"
+	}
+	if statusCode >= 500 {
+		return "exception"
+	}
+	return "processing"
+}
+
+// encodeFHIRXML writes a resource in the FHIR XML format. Element names and
+// order come from the json tags, as FHIR resources are modelled on the JSON
+// format: resourceType becomes the element name, primitives become value
+// attributes, id and extension urls on elements become attributes and the
+// narrative div is written as it is, after checking it is well-formed.
+// Primitive extensions (the _name JSON properties) are not supported.
+// Resources should be structs: maps are written with their keys sorted, which
+// is rarely the element order FHIR requires.
+func encodeFHIRXML(w io.Writer, resource interface{}, opts EncodeOptions) error {
+	e := fhirXMLEncoder{w: w, enc: xml.NewEncoder(w)}
+	v := indirect(reflect.ValueOf(resource))
+	if !v.IsValid() {
+		return nil
+	}
+
+	name, err := fhirXMLRoot(v, opts)
+	if err != nil {
+		return err
+	}
+	start := xml.StartElement{
+		Name: xml.Name{Local: name},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: FHIRNamespace}},
+	}
+	if err := e.complex(start, v, true); err != nil {
+		return err
+	}
+	return e.enc.Flush()
+}
+
+type fhirXMLEncoder struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+type fhirProperty struct {
+	name      string
+	value     reflect.Value
+	omitEmpty bool
+}
+
+// properties lists the JSON properties of a struct or map in order
+func fhirProperties(v reflect.Value) []fhirProperty {
+	var properties []fhirProperty
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				continue
+			}
+			tag := strings.Split(field.Tag.Get("json"), ",")
+			if tag[0] == "-" {
+				continue
+			}
+			if field.Anonymous && tag[0] == "" {
+				if embedded := indirect(v.Field(i)); embedded.Kind() == reflect.Struct {
+					properties = append(properties, fhirProperties(embedded)...)
+				}
+				continue
+			}
+			name := tag[0]
+			if name == "" {
+				name = field.Name
+			}
+			omitEmpty := false
+			for _, option := range tag[1:] {
+				omitEmpty = omitEmpty || option == "omitempty"
+			}
+			properties = append(properties, fhirProperty{name: name, value: v.Field(i), omitEmpty: omitEmpty})
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			properties = append(properties, fhirProperty{name: key.String(), value: v.MapIndex(key)})
+		}
+	}
+	return properties
+}
+
+// fhirResourceType returns the resourceType property of a struct or map, if any
+// fhirXMLRoot names the root element of a resource: its resourceType, or
+// the name of its type from before shaping. Respond checks it before
+// sending the status, as without a name the XML can't be written.
+func fhirXMLRoot(v reflect.Value, opts EncodeOptions) (string, error) {
+	if name := fhirResourceType(v); name != "" {
+		return name, nil
+	}
+	if opts.root != "" {
+		return opts.root, nil
+	}
+	if name := xmlTypeName(v.Type()); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("[fhir] %s has no resourceType to name the root element", v.Type())
+}
+
+func fhirResourceType(v reflect.Value) string {
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() != reflect.String {
+		return ""
+	}
+	for _, p := range fhirProperties(v) {
+		if p.name == "resourceType" {
+			if s := indirect(p.value); s.Kind() == reflect.String {
+				return s.String()
+			}
+		}
+	}
+	return ""
+}
+
+func (e fhirXMLEncoder) element(name string, v reflect.Value, omitEmpty bool) error {
+	v = indirect(v)
+	if !v.IsValid() || (omitEmpty && v.IsZero()) {
+		return nil
+	}
+
+	switch {
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8, v.Kind() == reflect.Array:
+		// Repeating elements
+		for i := 0; i < v.Len(); i++ {
+			if err := e.element(name, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	// Times and TextMarshalers are primitives, as they are single CSV cells
+	case (v.Kind() == reflect.Struct && !isCSVValue(v.Type())) || (v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String):
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		resourceType := fhirResourceType(v)
+		if resourceType == "" {
+			return e.complex(start, v, false)
+		}
+		// A contained resource is wrapped in an element named after its type
+		if err := e.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := e.complex(xml.StartElement{Name: xml.Name{Local: resourceType}}, v, true); err != nil {
+			return err
+		}
+		return e.enc.EncodeToken(start.End())
+
+	case name == "div" && v.Kind() == reflect.String:
+		// Narrative XHTML, already markup. Only written once it is known to
+		// be a single well-formed element, so it can't break the document.
+		if err := checkNarrative(v.String()); err != nil {
+			return err
+		}
+		if err := e.enc.Flush(); err != nil {
+			return err
+		}
+		_, err := io.WriteString(e.w, v.String())
+		return err
+	}
+
+	value, err := fhirPrimitive(v)
+	if err != nil || value == "" {
+		return err
+	}
+	start := xml.StartElement{
+		Name: xml.Name{Local: name},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "value"}, Value: value}},
+	}
+	if err := e.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	return e.enc.EncodeToken(start.End())
+}
+
+func (e fhirXMLEncoder) complex(start xml.StartElement, v reflect.Value, isResource bool) error {
+	properties := fhirProperties(v)
+
+	// Element ids and extension urls are attributes, a resource's id is an element
+	var children []fhirProperty
+	for _, p := range properties {
+		attribute := (p.name == "id" && !isResource) ||
+			(p.name == "url" && (start.Name.Local == "extension" || start.Name.Local == "modifierExtension"))
+		switch {
+		case p.name == "resourceType" && isResource, strings.HasPrefix(p.name, "_"):
+		case attribute:
+			if value, err := fhirPrimitive(indirect(p.value)); err == nil && value != "" {
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: p.name}, Value: value})
+			}
+		default:
+			children = append(children, p)
+		}
+	}
+
+	if err := e.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, p := range children {
+		if err := e.element(p.name, p.value, p.omitEmpty); err != nil {
+			return err
+		}
+	}
+	return e.enc.EncodeToken(start.End())
+}
+
+// checkNarrative makes sure a narrative is one well-formed div element with
+// nothing but whitespace around it
+func checkNarrative(div string) error {
+	dec := xml.NewDecoder(strings.NewReader(div))
+	depth, roots := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("[fhir] narrative is not well-formed XHTML: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+				if roots > 1 || t.Name.Local != "div" {
+					return fmt.Errorf("[fhir] narrative must be a single div element")
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && strings.TrimSpace(string(t)) != "" {
+				return fmt.Errorf("[fhir] narrative must be a single div element")
+			}
+		case xml.ProcInst, xml.Directive:
+			return fmt.Errorf("[fhir] narrative must be a single div element")
+		}
+	}
+	if roots == 0 {
+		return fmt.Errorf("[fhir] narrative must be a single div element")
+	}
+	return nil
+}
+
+// fhirPrimitive formats a primitive for a value attribute, empty values are
+// left out as FHIR doesn't allow them
+func fhirPrimitive(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "", nil
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		// base64Binary
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("[fhir] cannot encode %s as XML", v.Type())
+}
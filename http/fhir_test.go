@@ -0,0 +1,47 @@
+package http
+
+import (
+	basehttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fhirPatient struct {
+	ID  string `json:"id"`
+	NHS string `json:"nhs_number" redact:"pii"`
+}
+
+func TestRespondFHIRXMLRoot(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		response   interface{}
+		wantStatus int
+		wantRoot   string
+	}{
+		{name: "named type", target: "/", response: fhirPatient{ID: "p1", NHS: "943"}, wantStatus: 200, wantRoot: "<fhirPatient "},
+		{name: "fields parameter", target: "/?" + FieldsParam + "=id", response: &fhirPatient{ID: "p1"}, wantStatus: 200, wantRoot: "<fhirPatient "},
+		{name: "resourceType", target: "/", response: map[string]string{"resourceType": "Patient", "id": "p1"}, wantStatus: 200, wantRoot: "<Patient "},
+		{name: "no name", target: "/", response: struct {
+			ID string `json:"id"`
+		}{ID: "p1"}, wantStatus: 500, wantRoot: "<OperationOutcome "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.target, nil)
+			r.Header.Set("Accept", ContentTypeFHIRXML)
+			rec := httptest.NewRecorder()
+			Respond(rec, r, basehttp.StatusOK, test.response)
+			if rec.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, test.wantStatus)
+			}
+			if !strings.HasPrefix(rec.Body.String(), test.wantRoot) {
+				t.Errorf("body = %s, want it to start %s", rec.Body, test.wantRoot)
+			}
+			if strings.Contains(rec.Body.String(), "943") {
+				t.Errorf("redacted field sent: %s", rec.Body)
+			}
+		})
+	}
+}
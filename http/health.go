@@ -0,0 +1,225 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	basehttp "net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DocHQ/logging"
+)
+
+// DefaultHealthCheckTimeout is used for checks registered without a Timeout
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// HealthCheck is a named check of something the service depends on, e.g. the
+// database or another service. Register them with RegisterHealthCheck.
+type HealthCheck struct {
+	// Name identifies the check in the /health/ready response
+	Name string
+
+	// Check returns nil if the dependency is usable, it should give up when
+	// ctx is done
+	Check func(ctx context.Context) error
+
+	// Timeout limits how long Check may take, DefaultHealthCheckTimeout if 0
+	Timeout time.Duration
+
+	// Critical checks make the service not ready (503) when they fail, other
+	// checks only show up as failing in the response body
+	Critical bool
+
+	// CacheFor reuses the last result for this long, to stop frequent probes
+	// hammering the dependency. 0 runs the check on every probe.
+	CacheFor time.Duration
+}
+
+// HealthCheckResult is the outcome of a single check in the /health/ready body
+type HealthCheckResult struct {
+	Status     string  `json:"status"`
+	Critical   bool    `json:"critical"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+	Cached     bool    `json:"cached,omitempty"`
+}
+
+// HealthReport is the body of the /health/ready response
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+// Health statuses used in HealthReport and HealthCheckResult
+const (
+	HealthStatusOK           = "ok"
+	HealthStatusDegraded     = "degraded"
+	HealthStatusFail         = "fail"
+	HealthStatusShuttingDown = "shutting_down"
+)
+
+// RegisterHealthCheck adds a check to /health/ready, registering a check with
+// the same name replaces it
+func RegisterHealthCheck(check HealthCheck) {
+	if check.Name == "" || check.Check == nil {
+		panic("[RegisterHealthCheck] a health check needs a Name and a Check function")
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthCheckTimeout
+	}
+
+	healthChecks.Lock()
+	defer healthChecks.Unlock()
+	if _, ok := healthChecks.byName[check.Name]; !ok {
+		healthChecks.order = append(healthChecks.order, check.Name)
+	}
+	healthChecks.byName[check.Name] = &registeredHealthCheck{HealthCheck: check}
+}
+
+// CheckHealth runs the registered checks and reports the overall status, this
+// is what /health/ready returns
+func CheckHealth(ctx context.Context) HealthReport {
+	healthChecks.RLock()
+	checks := make([]*registeredHealthCheck, 0, len(healthChecks.order))
+	for _, name := range healthChecks.order {
+		checks = append(checks, healthChecks.byName[name])
+	}
+	healthChecks.RUnlock()
+
+	report := HealthReport{Status: HealthStatusOK}
+	if len(checks) > 0 {
+		report.Checks = make(map[string]HealthCheckResult, len(checks))
+	}
+
+	// Run the checks in parallel so the slowest one decides the latency
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *registeredHealthCheck) {
+			defer wg.Done()
+			results[i] = check.run(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range checks {
+		result := results[i]
+		report.Checks[check.Name] = result
+		if result.Status == HealthStatusOK {
+			continue
+		}
+		if check.Critical {
+			report.Status = HealthStatusFail
+		} else if report.Status == HealthStatusOK {
+			report.Status = HealthStatusDegraded
+		}
+	}
+
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		report.Status = HealthStatusShuttingDown
+	}
+
+	return report
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+var healthChecks = struct {
+	sync.RWMutex
+	order  []string
+	byName map[string]*registeredHealthCheck
+}{byName: map[string]*registeredHealthCheck{}}
+
+// shuttingDown is set by StartServer once a stop signal has been received so
+// that the load balancer stops sending new requests while we drain
+var shuttingDown int32
+
+type registeredHealthCheck struct {
+	HealthCheck
+
+	mu      sync.Mutex
+	last    HealthCheckResult
+	lastRun time.Time
+}
+
+func (c *registeredHealthCheck) run(ctx context.Context) HealthCheckResult {
+	if c.CacheFor > 0 {
+		c.mu.Lock()
+		if !c.lastRun.IsZero() && time.Since(c.lastRun) < c.CacheFor {
+			result := c.last
+			c.mu.Unlock()
+			result.Cached = true
+			return result
+		}
+		c.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errc <- fmt.Errorf("health check panicked: %v", p)
+			}
+		}()
+		errc <- c.Check(ctx)
+	}()
+
+	// Don't trust the check to honour the context
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := HealthCheckResult{
+		Status:     HealthStatusOK,
+		Critical:   c.Critical,
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Status = HealthStatusFail
+		result.Error = err.Error()
+	}
+
+	if c.CacheFor > 0 {
+		c.mu.Lock()
+		c.last, c.lastRun = result, time.Now()
+		c.mu.Unlock()
+	}
+
+	return result
+}
+
+// healthLiveHandler only shows the process is up and serving, it must not
+// depend on anything else or an outage elsewhere will restart every pod
+func healthLiveHandler(w basehttp.ResponseWriter, r *basehttp.Request) {
+	writeHealth(w, basehttp.StatusOK, HealthReport{Status: HealthStatusOK})
+}
+
+func healthReadyHandler(w basehttp.ResponseWriter, r *basehttp.Request) {
+	report := CheckHealth(r.Context())
+
+	statusCode := basehttp.StatusOK
+	if report.Status == HealthStatusFail || report.Status == HealthStatusShuttingDown {
+		statusCode = basehttp.StatusServiceUnavailable
+	}
+	writeHealth(w, statusCode, report)
+}
+
+func writeHealth(w basehttp.ResponseWriter, statusCode int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.Error(err)
+	}
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	basehttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func resetHealthChecks() {
+	healthChecks.Lock()
+	healthChecks.order = nil
+	healthChecks.byName = map[string]*registeredHealthCheck{}
+	healthChecks.Unlock()
+	atomic.StoreInt32(&shuttingDown, 0)
+}
+
+func passing(ctx context.Context) error { return nil }
+func failing(ctx context.Context) error { return errors.New("connection refused") }
+
+func TestHealthReady(t *testing.T) {
+	defer resetHealthChecks()
+
+	tests := []struct {
+		name         string
+		checks       []HealthCheck
+		shuttingDown bool
+		wantCode     int
+		wantStatus   string
+	}{
+		{"no checks", nil, false, 200, HealthStatusOK},
+		{"passing", []HealthCheck{{Name: "db", Check: passing, Critical: true}}, false, 200, HealthStatusOK},
+		{"non-critical failing", []HealthCheck{{Name: "db", Check: passing, Critical: true}, {Name: "mail", Check: failing}}, false, 200, HealthStatusDegraded},
+		{"critical failing", []HealthCheck{{Name: "db", Check: failing, Critical: true}, {Name: "mail", Check: failing}}, false, 503, HealthStatusFail},
+		{"panicking", []HealthCheck{{Name: "db", Check: func(ctx context.Context) error { panic("boom") }, Critical: true}}, false, 503, HealthStatusFail},
+		{"timed out", []HealthCheck{{Name: "db", Check: func(ctx context.Context) error { select {} }, Timeout: 10 * time.Millisecond, Critical: true}}, false, 503, HealthStatusFail},
+		{"shutting down", []HealthCheck{{Name: "db", Check: passing, Critical: true}}, true, 503, HealthStatusShuttingDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetHealthChecks()
+			for _, check := range tt.checks {
+				RegisterHealthCheck(check)
+			}
+			if tt.shuttingDown {
+				atomic.StoreInt32(&shuttingDown, 1)
+			}
+
+			w := httptest.NewRecorder()
+			New().ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", cc)
+			}
+			var report HealthReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", report.Status, tt.wantStatus)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("got %d checks, want %d", len(report.Checks), len(tt.checks))
+			}
+			for _, check := range tt.checks {
+				result := report.Checks[check.Name]
+				if result.Critical != check.Critical || (result.Status == HealthStatusFail) != (result.Error != "") {
+					t.Errorf("%s = %+v", check.Name, result)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthLiveIgnoresChecks(t *testing.T) {
+	defer resetHealthChecks()
+	RegisterHealthCheck(HealthCheck{Name: "db", Check: failing, Critical: true})
+
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest("GET", "/health/live", nil))
+	if w.Code != basehttp.StatusOK || w.Body.String() != `{"status":"ok"}`+"\n" {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHealthCheckCache(t *testing.T) {
+	defer resetHealthChecks()
+
+	var calls int32
+	RegisterHealthCheck(HealthCheck{Name: "db", CacheFor: time.Minute, Check: func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}})
+
+	first := CheckHealth(context.Background())
+	second := CheckHealth(context.Background())
+	if calls != 1 {
+		t.Errorf("check ran %d times, want 1", calls)
+	}
+	if first.Checks["db"].Cached || !second.Checks["db"].Cached {
+		t.Errorf("cached = %v then %v, want false then true", first.Checks["db"].Cached, second.Checks["db"].Cached)
+	}
+}
+
+func TestRegisterHealthCheckReplaces(t *testing.T) {
+	defer resetHealthChecks()
+	RegisterHealthCheck(HealthCheck{Name: "db", Check: failing, Critical: true})
+	RegisterHealthCheck(HealthCheck{Name: "db", Check: passing, Critical: true})
+
+	report := CheckHealth(context.Background())
+	if report.Status != HealthStatusOK || len(report.Checks) != 1 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestIsHealthPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/health", true},
+		{"/health/live", true},
+		{"/health/ready", true},
+		{"/health/", false},
+		{"/health/extra", false},
+		{"/healthz", false},
+		{"/api/health", false},
+	}
+	for _, tt := range tests {
+		if got := isHealthPath(tt.path); got != tt.want {
+			t.Errorf("isHealthPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
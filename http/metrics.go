@@ -0,0 +1,167 @@
+package http
+
+import (
+	basehttp "net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DocHQ/helpers/internal/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultMetricsPath is where WithMetrics exposes the metrics if no path is given
+const DefaultMetricsPath = "/metrics"
+
+// WithMetrics records Prometheus metrics for every route on the Router and
+// exposes them, along with everything else in the default registry, at path
+// (DefaultMetricsPath if empty). The metrics are labelled by route template,
+// method and status code:
+//
+//	http_requests_total
+//	http_request_duration_seconds
+//	http_requests_in_flight
+//	http_response_size_bytes
+//
+// The path is behind Authorize like every other route, so Prometheus has to
+// send the service key (authorization.credentials in the scrape config), or
+// use WithPublicMetrics.
+func WithMetrics(path string) Option {
+	return withMetrics(path, false)
+}
+
+// WithPublicMetrics is WithMetrics with Authorize letting requests for the
+// metrics through without the service key, the same way it does for /health.
+// Authorize has to be added with the Router's Use for this, so it runs once
+// the route is known. Only use it where the metrics can't be reached from
+// outside.
+func WithPublicMetrics(path string) Option {
+	return withMetrics(path, true)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+func withMetrics(path string, public bool) Option {
+	return func(r *Router) {
+		if path == "" {
+			path = DefaultMetricsPath
+		}
+		handler := promhttp.Handler()
+		if public {
+			handler = publicHandler{handler}
+		}
+
+		r.Use(metricsMiddleware(registerHTTPMetrics()))
+		r.Handle(path, handler).Methods("GET")
+	}
+}
+
+// publicHandler marks the handler of a route Authorize lets through without
+// the service key
+type publicHandler struct {
+	basehttp.Handler
+}
+
+// isPublicRoute reports whether the request matched a route with a
+// publicHandler
+func isPublicRoute(r *basehttp.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	_, ok := route.GetHandler().(publicHandler)
+	return ok
+}
+
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	size     *prometheus.HistogramVec
+}
+
+var (
+	httpMetricsOnce sync.Once
+	httpMetricsSet  *httpMetrics
+)
+
+// registerHTTPMetrics registers the collectors the first time a Router asks
+// for them, every Router shares the same ones
+func registerHTTPMetrics() *httpMetrics {
+	httpMetricsOnce.Do(func() {
+		labels := []string{"route", "method", "code"}
+		httpMetricsSet = &httpMetrics{
+			requests: metrics.Register(prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Number of HTTP requests handled, by route, method and status code.",
+			}, labels)).(*prometheus.CounterVec),
+			duration: metrics.Register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "Time taken to handle HTTP requests, by route, method and status code.",
+				Buckets: prometheus.DefBuckets,
+			}, labels)).(*prometheus.HistogramVec),
+			inFlight: metrics.Register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "http_requests_in_flight",
+				Help: "Number of HTTP requests currently being handled, by route and method.",
+			}, []string{"route", "method"})).(*prometheus.GaugeVec),
+			size: metrics.Register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "http_response_size_bytes",
+				Help:    "Size of HTTP response bodies, by route, method and status code.",
+				Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+			}, labels)).(*prometheus.HistogramVec),
+		}
+	})
+	return httpMetricsSet
+}
+
+func metricsMiddleware(m *httpMetrics) func(basehttp.Handler) basehttp.Handler {
+	return func(next basehttp.Handler) basehttp.Handler {
+		return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			route := ""
+			if current := mux.CurrentRoute(r); current != nil {
+				route, _ = current.GetPathTemplate()
+			}
+
+			inFlight := m.inFlight.WithLabelValues(route, r.Method)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			sw := statusWriter{ResponseWriter: w}
+
+			// A panic still counts, as the 500 Recovery sends or as whatever
+			// had been sent before the connection was aborted
+			panicked := true
+			defer func() {
+				if !panicked {
+					return
+				}
+				p := recover()
+				m.observe(route, r.Method, &sw, start, basehttp.StatusInternalServerError)
+				if p != nil {
+					panic(p)
+				}
+			}()
+
+			next.ServeHTTP(&sw, r)
+			panicked = false
+			m.observe(route, r.Method, &sw, start, basehttp.StatusOK)
+		})
+	}
+}
+
+// observe records a finished request, status is used if none was written
+func (m *httpMetrics) observe(route, method string, sw *statusWriter, start time.Time, status int) {
+	if sw.status != 0 {
+		status = sw.status
+	}
+	code := strconv.Itoa(status)
+
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.duration.WithLabelValues(route, method, code).Observe(time.Since(start).Seconds())
+	m.size.WithLabelValues(route, method, code).Observe(float64(sw.length))
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicMetricsPerRouter(t *testing.T) {
+	sink := accessLogSink
+	SetAccessLogSink(nil)
+	defer SetAccessLogSink(sink)
+
+	public := New(WithPublicMetrics(""))
+	public.Use(Authorize("key"))
+	private := New(WithMetrics(""))
+	private.Use(Authorize("key"))
+	other := New(WithPublicMetrics("/internal/metrics"))
+	other.Use(Authorize("key"))
+
+	tests := []struct {
+		name   string
+		router *Router
+		path   string
+		key    string
+		want   int
+	}{
+		{name: "public", router: public, path: "/metrics", want: 200},
+		{name: "private without key", router: private, path: "/metrics", want: 403},
+		{name: "private with key", router: private, path: "/metrics", key: "key", want: 200},
+		{name: "own path", router: other, path: "/internal/metrics", want: 200},
+		{name: "health", router: private, path: "/health", want: 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.path, nil)
+			if test.key != "" {
+				r.Header.Set("Authorization", test.key)
+			}
+			rec := httptest.NewRecorder()
+			test.router.ServeHTTP(rec, r)
+			if rec.Code != test.want {
+				t.Errorf("status = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	nh "net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/DocHQ/logging"
+)
+
+// Defaults for PaginationOptions
+const (
+	DefaultPageLimit    = 20
+	DefaultMaxPageLimit = 100
+)
+
+// PaginationOptions configures ParsePageRequest and RespondPage
+type PaginationOptions struct {
+	// DefaultLimit is used when the client doesn't send a limit,
+	// DefaultPageLimit if 0
+	DefaultLimit int
+
+	// MaxLimit is the largest limit a client may ask for,
+	// DefaultMaxPageLimit if 0
+	MaxLimit int
+
+	// Secret signs cursors so clients can't forge them. If empty the
+	// PAGINATION_CURSOR_SECRET environment variable is used, and failing that
+	// a random secret that only lasts for the life of the process, which is
+	// logged as a warning the first time it is used. Multiple replicas need
+	// the same secret.
+	Secret []byte
+}
+
+// Cursor is the position a page starts from, sent to clients as an opaque
+// signed string
+type Cursor struct {
+	// Offset is the number of items to skip
+	Offset int `json:"o,omitempty"`
+
+	// After is the sort key of the last item on the previous page, for
+	// keyset pagination
+	After string `json:"a,omitempty"`
+}
+
+// PageRequest is the page a client asked for
+type PageRequest struct {
+	Limit  int
+	Offset int
+
+	// After is set when the client sent a cursor for keyset pagination
+	After string
+
+	// usedCursor records whether the client paginates with cursors, so the
+	// links in the response do the same
+	usedCursor bool
+}
+
+// PageResult is what a handler found for a PageRequest
+type PageResult struct {
+	// Items is the slice of items on this page
+	Items interface{}
+
+	// Total is the number of items across all pages, 0 if not known
+	Total int
+
+	// HasMore says there is another page, for when Total isn't known
+	HasMore bool
+
+	// NextAfter is the sort key of the last item, for keyset pagination.
+	// Set HasMore as well if there is another page.
+	NextAfter string
+}
+
+// Page is the body RespondPage sends, the items plus where they sit in the
+// whole list
+type Page struct {
+	XMLName xml.Name    `json:"-" codec:"-" xml:"page"`
+	Items   interface{} `json:"items" xml:"items>item"`
+	Paging  PageInfo    `json:"paging" xml:"paging"`
+}
+
+// PageInfo describes the page and how to get the ones around it
+type PageInfo struct {
+	Limit      int    `json:"limit" xml:"limit"`
+	Offset     int    `json:"offset" xml:"offset"`
+	Total      int    `json:"total,omitempty" xml:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty" xml:"prev_cursor,omitempty"`
+	Next       string `json:"next,omitempty" xml:"next,omitempty"`
+	Prev       string `json:"prev,omitempty" xml:"prev,omitempty"`
+	First      string `json:"first,omitempty" xml:"first,omitempty"`
+}
+
+// ErrInvalidCursor is returned for cursors that weren't produced by EncodeCursor
+// with the same secret
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ParsePageRequest reads the limit, offset and cursor query parameters.
+// The error is suitable for returning to the client with a 400:
+//
+//	page, err := http.ParsePageRequest(r, opts)
+//	if err != nil {
+//		http.RespondError(w, r, nh.StatusBadRequest, err)
+//		return
+//	}
+func ParsePageRequest(r *nh.Request, opts PaginationOptions) (PageRequest, error) {
+	opts = opts.withDefaults()
+	query := r.URL.Query()
+	page := PageRequest{Limit: opts.DefaultLimit}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("limit must be a whole number greater than 0")
+		}
+		if limit > opts.MaxLimit {
+			return page, fmt.Errorf("limit must not be more than %d", opts.MaxLimit)
+		}
+		page.Limit = limit
+	}
+
+	offset, token := query.Get("offset"), query.Get("cursor")
+	if offset != "" && token != "" {
+		return page, fmt.Errorf("use either offset or cursor, not both")
+	}
+	if offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return page, fmt.Errorf("offset must be a whole number, 0 or more")
+		}
+		page.Offset = n
+	}
+	if token != "" {
+		cursor, err := opts.DecodeCursor(token)
+		if err != nil {
+			return page, err
+		}
+		page.Offset, page.After, page.usedCursor = cursor.Offset, cursor.After, true
+	}
+
+	return page, nil
+}
+
+// EncodeCursor turns a cursor into an opaque string signed with the secret
+func (opts PaginationOptions) EncodeCursor(cursor Cursor) string {
+	payload, _ := json.Marshal(cursor) // a struct of an int and a string can't fail
+	mac := hmac.New(sha256.New, opts.secret())
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// DecodeCursor checks the signature of a cursor from EncodeCursor and decodes it
+func (opts PaginationOptions) DecodeCursor(token string) (Cursor, error) {
+	var cursor Cursor
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return cursor, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	mac := hmac.New(sha256.New, opts.secret())
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)[:16]) {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(payload, &cursor); err != nil || cursor.Offset < 0 {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// RespondPage sends a page of results wrapped in a Page, in whichever format
+// the client accepts, with RFC 8288 Link headers for the next, previous and
+// first pages. The links keep the request's other query parameters and use
+// cursors if the client did, otherwise offsets.
+func RespondPage(w nh.ResponseWriter, r *nh.Request, request PageRequest, result PageResult, opts PaginationOptions) {
+	opts = opts.withDefaults()
+	info := PageInfo{
+		Limit:  request.Limit,
+		Offset: request.Offset,
+		Total:  result.Total,
+	}
+
+	var links []string
+	link := func(rel string, cursor Cursor) string {
+		href := pageURL(r.URL, request, cursor, opts)
+		links = append(links, fmt.Sprintf("<%s>; rel=%q", href, rel))
+		return href
+	}
+
+	hasNext := result.HasMore || (result.Total > 0 && request.Offset+request.Limit < result.Total)
+	if hasNext {
+		next := Cursor{Offset: request.Offset + request.Limit}
+		if result.NextAfter != "" {
+			next = Cursor{After: result.NextAfter}
+		}
+		info.NextCursor = opts.EncodeCursor(next)
+		info.Next = link("next", next)
+	}
+	// Keyset pages can only go forwards
+	if request.Offset > 0 && request.After == "" {
+		prev := request.Offset - request.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		info.PrevCursor = opts.EncodeCursor(Cursor{Offset: prev})
+		info.Prev = link("prev", Cursor{Offset: prev})
+	}
+	if request.Offset > 0 || request.After != "" {
+		info.First = link("first", Cursor{})
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	Respond(w, r, nh.StatusOK, Page{Items: result.Items, Paging: info})
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// processCursorSecret is used when no secret is configured, cursors signed
+// with it stop working when the process restarts and aren't accepted by the
+// other replicas
+var processCursorSecret = func() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}()
+
+var warnCursorSecret sync.Once
+
+func (opts PaginationOptions) withDefaults() PaginationOptions {
+	if opts.DefaultLimit <= 0 {
+		opts.DefaultLimit = DefaultPageLimit
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = DefaultMaxPageLimit
+	}
+	if opts.DefaultLimit > opts.MaxLimit {
+		opts.DefaultLimit = opts.MaxLimit
+	}
+	return opts
+}
+
+func (opts PaginationOptions) secret() []byte {
+	if len(opts.Secret) > 0 {
+		return opts.Secret
+	}
+	if env := os.Getenv("PAGINATION_CURSOR_SECRET"); env != "" {
+		return []byte(env)
+	}
+	warnCursorSecret.Do(func() {
+		logging.Warn("PAGINATION_CURSOR_SECRET is not set, page cursors are signed with a random key " +
+			"and will fail after a restart or on another replica. Set it to the same value everywhere.")
+	})
+	return processCursorSecret
+}
+
+// pageURL is the request URL with the paging parameters replaced
+func pageURL(u *url.URL, request PageRequest, cursor Cursor, opts PaginationOptions) string {
+	query := u.Query()
+	query.Del("offset")
+	query.Del("cursor")
+	query.Set("limit", strconv.Itoa(request.Limit))
+
+	if request.usedCursor || cursor.After != "" {
+		if cursor != (Cursor{}) {
+			query.Set("cursor", opts.EncodeCursor(cursor))
+		}
+	} else if cursor.Offset > 0 {
+		query.Set("offset", strconv.Itoa(cursor.Offset))
+	}
+
+	return (&url.URL{Path: u.Path, RawQuery: query.Encode()}).String()
+}
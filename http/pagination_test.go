@@ -0,0 +1,201 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testPaging = PaginationOptions{DefaultLimit: 10, MaxLimit: 50, Secret: []byte("test secret")}
+
+func TestParsePageRequest(t *testing.T) {
+	cursor := testPaging.EncodeCursor(Cursor{Offset: 30})
+	keyset := testPaging.EncodeCursor(Cursor{After: "patient-42"})
+
+	tests := []struct {
+		name    string
+		query   string
+		want    PageRequest
+		wantErr string
+	}{
+		{"defaults", "", PageRequest{Limit: 10}, ""},
+		{"limit and offset", "limit=25&offset=50", PageRequest{Limit: 25, Offset: 50}, ""},
+		{"offset cursor", "cursor=" + cursor, PageRequest{Limit: 10, Offset: 30, usedCursor: true}, ""},
+		{"keyset cursor", "limit=5&cursor=" + keyset, PageRequest{Limit: 5, After: "patient-42", usedCursor: true}, ""},
+		{"limit over the max", "limit=51", PageRequest{}, "limit must not be more than 50"},
+		{"zero limit", "limit=0", PageRequest{}, "limit must be a whole number greater than 0"},
+		{"limit not a number", "limit=ten", PageRequest{}, "limit must be a whole number greater than 0"},
+		{"negative offset", "offset=-1", PageRequest{}, "offset must be a whole number, 0 or more"},
+		{"offset and cursor", "offset=1&cursor=" + cursor, PageRequest{}, "use either offset or cursor, not both"},
+		{"forged cursor", "cursor=eyJvIjozMH0.AAAA", PageRequest{}, ErrInvalidCursor.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePageRequest(httptest.NewRequest("GET", "/patients?"+tt.query, nil), testPaging)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePageRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginationDefaults(t *testing.T) {
+	tests := []struct {
+		opts      PaginationOptions
+		wantLimit int
+		wantMax   int
+	}{
+		{PaginationOptions{}, DefaultPageLimit, DefaultMaxPageLimit},
+		{PaginationOptions{DefaultLimit: 5}, 5, DefaultMaxPageLimit},
+		{PaginationOptions{MaxLimit: 10}, 10, 10},
+		{PaginationOptions{DefaultLimit: 50, MaxLimit: 30}, 30, 30},
+	}
+	for _, tt := range tests {
+		got := tt.opts.withDefaults()
+		if got.DefaultLimit != tt.wantLimit || got.MaxLimit != tt.wantMax {
+			t.Errorf("%+v.withDefaults() = %d/%d, want %d/%d", tt.opts, got.DefaultLimit, got.MaxLimit, tt.wantLimit, tt.wantMax)
+		}
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	valid := testPaging.EncodeCursor(Cursor{Offset: 30, After: "patient-42"})
+	parts := strings.SplitN(valid, ".", 2)
+
+	tests := []struct {
+		name  string
+		opts  PaginationOptions
+		token string
+		ok    bool
+	}{
+		{"valid", testPaging, valid, true},
+		{"other secret", PaginationOptions{Secret: []byte("other")}, valid, false},
+		{"tampered payload", testPaging, "eyJvIjo5OTl9." + parts[1], false},
+		{"no signature", testPaging, parts[0], false},
+		{"bad base64", testPaging, "!!!." + parts[1], false},
+		{"empty", testPaging, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := tt.opts.DecodeCursor(tt.token)
+			if tt.ok {
+				if err != nil || cursor != (Cursor{Offset: 30, After: "patient-42"}) {
+					t.Errorf("DecodeCursor() = %+v, %v", cursor, err)
+				}
+			} else if err != ErrInvalidCursor {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestRespondPage(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		result    PageResult
+		wantLinks map[string]string
+	}{
+		{
+			name:      "first of several",
+			query:     "status=active",
+			result:    PageResult{Total: 25},
+			wantLinks: map[string]string{"next": "/patients?limit=10&offset=10&status=active"},
+		},
+		{
+			name:   "middle",
+			query:  "offset=10&status=active",
+			result: PageResult{Total: 25},
+			wantLinks: map[string]string{
+				"next":  "/patients?limit=10&offset=20&status=active",
+				"prev":  "/patients?limit=10&status=active",
+				"first": "/patients?limit=10&status=active",
+			},
+		},
+		{
+			name:   "last",
+			query:  "offset=20",
+			result: PageResult{Total: 25},
+			wantLinks: map[string]string{
+				"prev":  "/patients?limit=10&offset=10",
+				"first": "/patients?limit=10",
+			},
+		},
+		{
+			name:      "unknown total",
+			query:     "limit=5",
+			result:    PageResult{HasMore: true},
+			wantLinks: map[string]string{"next": "/patients?limit=5&offset=5"},
+		},
+		{
+			name:      "only page",
+			result:    PageResult{Total: 3},
+			wantLinks: map[string]string{},
+		},
+		{
+			name:   "keyset",
+			query:  "cursor=" + url.QueryEscape(testPaging.EncodeCursor(Cursor{After: "patient-10"})),
+			result: PageResult{HasMore: true, NextAfter: "patient-20"},
+			wantLinks: map[string]string{
+				"next":  "/patients?cursor=" + testPaging.EncodeCursor(Cursor{After: "patient-20"}) + "&limit=10",
+				"first": "/patients?limit=10",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/patients?"+tt.query, nil)
+			request, err := ParsePageRequest(r, testPaging)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.result.Items = []string{"a", "b"}
+			w := httptest.NewRecorder()
+			RespondPage(w, r, request, tt.result, testPaging)
+
+			links := map[string]string{}
+			if header := w.Header().Get("Link"); header != "" {
+				for _, link := range strings.Split(header, ", ") {
+					var href, rel string
+					parts := strings.SplitN(link, "; ", 2)
+					href = strings.Trim(parts[0], "<>")
+					rel = strings.Trim(strings.TrimPrefix(parts[1], "rel="), `"`)
+					links[rel] = href
+				}
+			}
+			if len(links) != len(tt.wantLinks) {
+				t.Errorf("Link = %q, want %v", w.Header().Get("Link"), tt.wantLinks)
+			}
+			for rel, want := range tt.wantLinks {
+				if links[rel] != want {
+					t.Errorf("%s = %q, want %q", rel, links[rel], want)
+				}
+			}
+
+			var page struct {
+				Items  []string `json:"items"`
+				Paging PageInfo `json:"paging"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != 2 || page.Paging.Next != links["next"] || page.Paging.Prev != links["prev"] || page.Paging.First != links["first"] {
+				t.Errorf("body = %s", w.Body.String())
+			}
+			if (page.Paging.NextCursor != "") != (links["next"] != "") {
+				t.Errorf("next_cursor = %q with next link %q", page.Paging.NextCursor, links["next"])
+			}
+		})
+	}
+}
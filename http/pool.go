@@ -0,0 +1,59 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+)
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+//
+// Respond is on the path of every request, so the handles, encoders and
+// buffers it uses are reused rather than allocated each time.
+
+// codecPool reuses the encoders for one handle, each has a write buffer and
+// caches what it learns about types
+type codecPool struct {
+	handle codec.Handle
+	pool   sync.Pool
+}
+
+func newCodecPool(handle codec.Handle) *codecPool {
+	return &codecPool{handle: handle}
+}
+
+// encode writes v to w with a pooled encoder
+func (p *codecPool) encode(w io.Writer, v interface{}) error {
+	enc, ok := p.pool.Get().(*codec.Encoder)
+	if ok {
+		enc.Reset(w)
+	} else {
+		enc = codec.NewEncoder(w, p.handle)
+	}
+	err := enc.Encode(v)
+	// Don't keep the writer alive while the encoder is in the pool
+	enc.Reset(ioutil.Discard)
+	p.pool.Put(enc)
+	return err
+}
+
+// maxPooledBuffer stops one huge response keeping its memory for ever
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+type benchAddress struct {
+	Line1    string `json:"line1"`
+	City     string `json:"city"`
+	Postcode string `json:"postcode"`
+}
+
+type benchPatient struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Email     string       `json:"email"`
+	BirthDate time.Time    `json:"birth_date"`
+	Tags      []string     `json:"tags"`
+	Address   benchAddress `json:"address"`
+	Active    bool         `json:"active"`
+	Visits    int          `json:"visits"`
+}
+
+func benchPatients() []benchPatient {
+	patients := make([]benchPatient, 50)
+	for i := range patients {
+		patients[i] = benchPatient{
+			ID:        "7c9e6679-7425-40de-944b-e07fc1f90ae7",
+			Name:      "Ada Lovelace",
+			Email:     "ada@example.com",
+			BirthDate: time.Date(1815, 12, 10, 0, 0, 0, 0, time.UTC),
+			Tags:      []string{"vip", "returning"},
+			Address:   benchAddress{Line1: "12 St James's Square", City: "London", Postcode: "SW1Y 4JH"},
+			Active:    true,
+			Visits:    i,
+		}
+	}
+	return patients
+}
+
+// The unpooled benchmarks encode the way Respond did before handles,
+// encoders and buffers were reused: new ones for every response
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	patients := benchPatients()
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := jsonCodec(EncodeOptions{}).encode(ioutil.Discard, patients); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json := &codec.JsonHandle{}
+			json.Canonical = true
+			if err := codec.NewEncoder(ioutil.Discard, json).Encode(patients); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncodeCBOR(b *testing.B) {
+	patients := benchPatients()
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := cborCodec.encode(ioutil.Discard, patients); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := codec.NewEncoder(ioutil.Discard, &codec.CborHandle{}).Encode(patients); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncodeXML(b *testing.B) {
+	patients := benchPatients()
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getBuffer()
+			if err := encodeXML(buf, patients, EncodeOptions{}); err != nil {
+				b.Fatal(err)
+			}
+			ioutil.Discard.Write(buf.Bytes())
+			putBuffer(buf)
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := new(bytes.Buffer)
+			if err := encodeXML(buf, patients, EncodeOptions{}); err != nil {
+				b.Fatal(err)
+			}
+			ioutil.Discard.Write(buf.Bytes())
+		}
+	})
+}
+
+func BenchmarkRenderHTML(b *testing.B) {
+	err := SetHTMLTemplates(HTMLTemplateOptions{
+		FS:    fstest.MapFS{"patients.html": {Data: []byte(`<ul>{{range .}}<li>{{.Name}} {{.Email}} {{.Address.City}}</li>{{end}}</ul>`)}},
+		Pages: []string{"*.html"},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer resetHTMLTemplates()
+	patients := benchPatients()
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := renderHTML(ioutil.Discard, "patients.html", patients); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		pages, err := currentHTMLEngine().load()
+		if err != nil {
+			b.Fatal(err)
+		}
+		t := pages["patients.html"]
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var out bytes.Buffer
+			if err := t.ExecuteTemplate(&out, "patients.html", patients); err != nil {
+				b.Fatal(err)
+			}
+			io.WriteString(ioutil.Discard, out.String())
+		}
+	})
+}
+
+func BenchmarkBuffer(b *testing.B) {
+	body := make([]byte, 8<<10)
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getBuffer()
+			buf.Write(body)
+			putBuffer(buf)
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := new(bytes.Buffer)
+			buf.Write(body)
+		}
+	})
+}
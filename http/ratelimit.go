@@ -0,0 +1,224 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	basehttp "net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DocHQ/helpers/ratelimit"
+	"github.com/DocHQ/logging"
+
+	"github.com/gorilla/mux"
+)
+
+// RateLimitOptions configures the RateLimit middleware
+type RateLimitOptions struct {
+	// Limiter applies to every route without an entry in Routes, nil means
+	// those routes are not limited
+	Limiter *ratelimit.Limiter
+
+	// Routes sets a limit for particular routes, keyed by mux path template
+	// e.g. "/users/{id}". Each route is counted separately.
+	Routes map[string]*ratelimit.Limiter
+
+	// Key picks who the limit applies to, RateLimitByIP if nil. Return "" to
+	// not limit a request.
+	Key func(r *basehttp.Request) string
+
+	// FailClosed rejects requests when the store can't be reached, by default
+	// they are let through and the error is logged
+	FailClosed bool
+}
+
+// RateLimit is middleware that rejects callers making too many requests with
+// a 429 through RespondError. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, rejected ones also have
+// Retry-After.
+//
+//	router.Use(http.RateLimit(http.RateLimitOptions{
+//		Limiter: ratelimit.New(store, ratelimit.Limit{Requests: 100, Period: time.Minute}),
+//		Routes: map[string]*ratelimit.Limiter{
+//			"/login": ratelimit.New(store, ratelimit.Limit{Requests: 5, Period: time.Minute}),
+//		},
+//	}))
+func RateLimit(opts RateLimitOptions) func(basehttp.Handler) basehttp.Handler {
+	if opts.Key == nil {
+		opts.Key = RateLimitByIP
+	}
+
+	return func(next basehttp.Handler) basehttp.Handler {
+		return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			limiter, scope := opts.Limiter, ""
+			if current := mux.CurrentRoute(r); current != nil && opts.Routes != nil {
+				if tmpl, err := current.GetPathTemplate(); err == nil {
+					if routeLimiter, ok := opts.Routes[tmpl]; ok {
+						limiter, scope = routeLimiter, tmpl+":"
+					}
+				}
+			}
+
+			key := opts.Key(r)
+			if limiter == nil || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), scope+key)
+			if err != nil {
+				logging.Errorf("rate limit store error: %v", err)
+				if opts.FailClosed {
+					RespondError(w, r, basehttp.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setRateLimitHeaders(w.Header(), result)
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				RespondError(w, r, basehttp.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitByIP limits each client address separately. X-Forwarded-For is
+// only believed from the proxies set with SetTrustedProxies, otherwise the
+// address of the connection is used.
+func RateLimitByIP(r *basehttp.Request) string {
+	return "ip:" + trustedClientIP(r)
+}
+
+// SetTrustedProxies sets the load balancers and proxies in front of the
+// service, as CIDRs or single addresses e.g. "10.0.0.0/8" or "35.191.0.1".
+// For requests from one of them RateLimitByIP takes the right-most address in
+// X-Forwarded-For that isn't a trusted proxy, which is the one the client
+// couldn't have made up.
+// The default is read from HTTP_TRUSTED_PROXIES, comma separated.
+func SetTrustedProxies(proxies ...string) error {
+	nets, err := parseProxies(proxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies.Lock()
+	defer trustedProxies.Unlock()
+	trustedProxies.nets = nets
+	return nil
+}
+
+// RateLimitByCaller limits each authenticated caller separately, using the
+// caller recorded with SetCaller or otherwise the Authorization header, and
+// falls back to the client address for anonymous requests
+func RateLimitByCaller(r *basehttp.Request) string {
+	if caller := Caller(r.Context()); caller != "" {
+		return "caller:" + caller
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		// Don't keep credentials in the store
+		sum := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(sum[:16])
+	}
+	return RateLimitByIP(r)
+}
+
+var trustedProxies struct {
+	sync.RWMutex
+	nets []*net.IPNet
+}
+
+func init() {
+	if proxies := os.Getenv("HTTP_TRUSTED_PROXIES"); proxies != "" {
+		if err := SetTrustedProxies(strings.Split(proxies, ",")...); err != nil {
+			logging.Errorf("invalid HTTP_TRUSTED_PROXIES: %v", err)
+		}
+	}
+}
+
+func parseProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	trustedProxies.RLock()
+	defer trustedProxies.RUnlock()
+	for _, ipNet := range trustedProxies.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedClientIP walks back through X-Forwarded-For from the connection's
+// address for as long as the hops are trusted proxies
+func trustedClientIP(r *basehttp.Request) string {
+	client := remoteHost(r)
+	if !isTrustedProxy(client) {
+		return client
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// Not something a proxy would add, stop at the last good hop
+			break
+		}
+		client = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func setRateLimitHeaders(header basehttp.Header, result ratelimit.Result) {
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+// ceilSeconds rounds up, the headers only allow whole seconds and rounding
+// down would tell clients to come back too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	basehttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DocHQ/helpers/ratelimit"
+)
+
+func TestRateLimitByIP(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.0/8", "35.191.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTrustedProxies() })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "ip:203.0.113.7"},
+		{"spoofed from an untrusted peer", "203.0.113.7:1234", []string{"1.2.3.4"}, "ip:203.0.113.7"},
+		{"behind a trusted proxy", "10.1.2.3:1234", []string{"203.0.113.7"}, "ip:203.0.113.7"},
+		{"spoofed behind a trusted proxy", "10.1.2.3:1234", []string{"1.2.3.4, 203.0.113.7"}, "ip:203.0.113.7"},
+		{"through two trusted proxies", "10.1.2.3:1234", []string{"1.2.3.4, 203.0.113.7", "35.191.0.1"}, "ip:203.0.113.7"},
+		{"garbage from the client", "10.1.2.3:1234", []string{"not-an-ip"}, "ip:10.1.2.3"},
+		{"only proxies", "10.1.2.3:1234", []string{"10.9.9.9"}, "ip:10.9.9.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, fwd := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", fwd)
+			}
+			if got := RateLimitByIP(r); got != tt.want {
+				t.Errorf("RateLimitByIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesRejectsInvalid(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if err := SetTrustedProxies("proxy.internal"); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limit{Requests: 2, Period: time.Minute})
+	handler := RateLimit(RateLimitOptions{Limiter: limiter})(basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		w.WriteHeader(basehttp.StatusNoContent)
+	}))
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		// A new X-Forwarded-For each time mustn't get the client a new allowance
+		r.Header.Set("X-Forwarded-For", "1.2.3."+strconv.Itoa(i))
+		handler.ServeHTTP(rec, r)
+		codes = append(codes, rec.Code)
+
+		if i == 2 {
+			if got := rec.Header().Get("Retry-After"); got != "30" {
+				t.Errorf("Retry-After = %q, want 30", got)
+			}
+			if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+				t.Errorf("RateLimit-Remaining = %q, want 0", got)
+			}
+		}
+	}
+	if codes[0] != basehttp.StatusNoContent || codes[1] != basehttp.StatusNoContent || codes[2] != basehttp.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want 204, 204, 429", codes)
+	}
+}
@@ -0,0 +1,94 @@
+package http
+
+import (
+	"fmt"
+	basehttp "net/http"
+	"runtime/debug"
+
+	"github.com/DocHQ/helpers/requestid"
+	"github.com/DocHQ/logging"
+	"github.com/DocHQ/logging/sentry"
+)
+
+// RecoveryOptions configures the Recovery middleware
+type RecoveryOptions struct {
+	// ReportToSentry sends recovered panics to Sentry as well as the log,
+	// the service must have called sentry.InitSentry
+	ReportToSentry bool
+}
+
+// WithSentry reports panics recovered by the Router to Sentry
+func WithSentry() Option {
+	return func(r *Router) {
+		r.recovery.ReportToSentry = true
+	}
+}
+
+// Recovery is middleware that stops a panicking handler taking the connection
+// down with it. New installs it on every Router.
+//
+// When Respond or RespondError panic because the response could not be
+// encoded the status has already been sent, so the panic is passed on as
+// http.ErrAbortHandler and the server drops the connection as they intend.
+// Any other panic is a bug: the stack is logged with the request id and the
+// client gets a 500 through RespondError, unless the handler had already
+// started writing in which case the connection is aborted too. Aborted
+// requests are still in the access log and metrics.
+func Recovery(opts RecoveryOptions) func(basehttp.Handler) basehttp.Handler {
+	return func(next basehttp.Handler) basehttp.Handler {
+		return recoveryHandler(next, &opts)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// recoveryHandler reads the options when a panic happens rather than when the
+// middleware is created, so New can install it before applying WithSentry
+func recoveryHandler(next basehttp.Handler, opts *RecoveryOptions) basehttp.Handler {
+	return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == basehttp.ErrAbortHandler {
+				panic(p)
+			}
+
+			id := requestid.FromContext(r.Context())
+
+			if abort, ok := p.(abortResponse); ok {
+				// net/http doesn't log ErrAbortHandler so do it here
+				logging.Errorf("%s request_id=%s", abort, id)
+				panic(basehttp.ErrAbortHandler)
+			}
+
+			err, ok := p.(error)
+			if !ok {
+				err = fmt.Errorf("%v", p)
+			}
+			fields := map[string]interface{}{
+				"request_id": id,
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"stack":      string(debug.Stack()),
+			}
+			logging.LogRunner(fmt.Sprintf("[Recovery] panic: %v request_id=%s\n%s", err, id, fields["stack"]), fields, logging.ERROR, logging.Verbose)
+			if opts.ReportToSentry {
+				sentry.Logger{}.Log(err, fields, logging.ERROR, logging.Verbose)
+			}
+
+			if sw.status != 0 {
+				// Too late to change the status, make sure the client can
+				// tell the response is incomplete
+				panic(basehttp.ErrAbortHandler)
+			}
+			RespondError(sw, r, basehttp.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"errors"
+	basehttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   basehttp.HandlerFunc
+		wantAbort bool
+		wantCode  int
+	}{
+		{
+			name:     "panic before writing",
+			handler:  func(w basehttp.ResponseWriter, r *basehttp.Request) { panic("boom") },
+			wantCode: 500,
+		},
+		{
+			name:     "panic with an error",
+			handler:  func(w basehttp.ResponseWriter, r *basehttp.Request) { panic(errors.New("boom")) },
+			wantCode: 500,
+		},
+		{
+			name: "panic after writing",
+			handler: func(w basehttp.ResponseWriter, r *basehttp.Request) {
+				w.WriteHeader(basehttp.StatusOK)
+				panic("boom")
+			},
+			wantAbort: true,
+			wantCode:  200,
+		},
+		{
+			name: "failed response",
+			handler: func(w basehttp.ResponseWriter, r *basehttp.Request) {
+				w.WriteHeader(basehttp.StatusOK)
+				panic(abortResponse("[RespondOk] failed to send response"))
+			},
+			wantAbort: true,
+			wantCode:  200,
+		},
+		{
+			name:      "already aborted",
+			handler:   func(w basehttp.ResponseWriter, r *basehttp.Request) { panic(basehttp.ErrAbortHandler) },
+			wantAbort: true,
+			wantCode:  200,
+		},
+		{
+			name:     "no panic",
+			handler:  func(w basehttp.ResponseWriter, r *basehttp.Request) { w.WriteHeader(basehttp.StatusNoContent) },
+			wantCode: 204,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() {
+					p := recover()
+					if p != nil && p != basehttp.ErrAbortHandler {
+						t.Fatalf("panicked with %v, want http.ErrAbortHandler", p)
+					}
+					aborted = p != nil
+				}()
+				Recovery(RecoveryOptions{})(tt.handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+				return false
+			}()
+
+			if aborted != tt.wantAbort {
+				t.Errorf("aborted = %v, want %v", aborted, tt.wantAbort)
+			}
+			if w.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == 500 && !strings.Contains(w.Body.String(), `"status_code":500`) {
+				t.Errorf("body = %s, want a ResponseError", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRecoveryLoggedAndCounted(t *testing.T) {
+	entries := captureAccessLog(t)
+
+	router := New(WithMetrics(""))
+	router.HandleFunc("/recovery/before", func(w basehttp.ResponseWriter, r *basehttp.Request) { panic("boom") })
+	router.HandleFunc("/recovery/after", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		w.WriteHeader(basehttp.StatusAccepted)
+		panic("boom")
+	})
+
+	requests := registerHTTPMetrics().requests
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantAborted bool
+	}{
+		{"/recovery/before", 500, false},
+		{"/recovery/after", 202, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			*entries = nil
+			counter := requests.WithLabelValues(tt.path, "GET", strconv.Itoa(tt.wantStatus))
+			before := testutil.ToFloat64(counter)
+
+			func() {
+				defer func() {
+					if p := recover(); p != nil && p != basehttp.ErrAbortHandler {
+						t.Fatalf("panicked with %v", p)
+					}
+				}()
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+			}()
+
+			if len(*entries) != 1 {
+				t.Fatalf("got %d access log entries, want 1", len(*entries))
+			}
+			if entry := (*entries)[0]; entry.Status != tt.wantStatus || entry.Aborted != tt.wantAborted {
+				t.Errorf("entry status = %d aborted = %v, want %d %v", entry.Status, entry.Aborted, tt.wantStatus, tt.wantAborted)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("http_requests_total went up by %v, want 1", got)
+			}
+		})
+	}
+}
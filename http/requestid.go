@@ -0,0 +1,36 @@
+package http
+
+import (
+	"context"
+	basehttp "net/http"
+
+	"github.com/DocHQ/helpers/requestid"
+)
+
+// RequestID is middleware that makes sure every request has a correlation ID.
+// The ID is taken from the X-Request-ID header, then the trace id of a W3C
+// traceparent header, and is otherwise generated. It is stored in the request
+// context (see RequestIDFromContext), echoed in the X-Request-ID response
+// header and included in the access log and in ResponseError bodies.
+// New installs this on every Router.
+func RequestID(next basehttp.Handler) basehttp.Handler {
+	return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.FromTraceparent(r.Header.Get("traceparent"))
+		}
+		if id == "" {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// RequestIDFromContext returns the ID set by the RequestID middleware. Pass the
+// context on to gRPC clients using the grpc package interceptors and the ID
+// follows the request to the next service.
+func RequestIDFromContext(ctx context.Context) string {
+	return requestid.FromContext(ctx)
+}
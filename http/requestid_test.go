@@ -0,0 +1,64 @@
+package http
+
+import (
+	basehttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DocHQ/helpers/requestid"
+)
+
+func TestRequestID(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		name   string
+		header basehttp.Header
+		want   string
+	}{
+		{"from the header", basehttp.Header{"X-Request-Id": {"abc-123"}}, "abc-123"},
+		{"from traceparent", basehttp.Header{"Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}, traceID},
+		{"header wins over traceparent", basehttp.Header{"X-Request-Id": {"abc-123"}, "Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}, "abc-123"},
+		{"invalid header falls back to traceparent", basehttp.Header{"X-Request-Id": {"has space"}, "Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}, traceID},
+		{"generated", nil, ""},
+		{"invalid header is replaced", basehttp.Header{"X-Request-Id": {strings.Repeat("a", 200)}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RequestID(basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+				got = RequestIDFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != nil {
+				r.Header = tt.header
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if tt.want == "" {
+				if len(got) != 32 || got == r.Header.Get(requestid.Header) {
+					t.Errorf("id = %q, want a generated one", got)
+				}
+			} else if got != tt.want {
+				t.Errorf("id = %q, want %q", got, tt.want)
+			}
+			if echoed := w.Header().Get(requestid.Header); echoed != got {
+				t.Errorf("%s = %q, want %q", requestid.Header, echoed, got)
+			}
+		})
+	}
+}
+
+func TestRequestIDInErrors(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(requestid.NewContext(r.Context(), "abc-123"))
+	w := httptest.NewRecorder()
+	RespondError(w, r, basehttp.StatusNotFound)
+
+	if body := w.Body.String(); !strings.Contains(body, `"request_id":"abc-123"`) {
+		t.Errorf("body = %s, want the request id", body)
+	}
+}
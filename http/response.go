@@ -1,72 +1,44 @@
 package http
 
 import (
-	"bytes"
-	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	nh "net/http"
-	"path/filepath"
 	"reflect"
 	"strings"
 
-	texttemplate "text/template"
+	"github.com/DocHQ/helpers/requestid"
 
-	"github.com/ugorji/go/codec"
+	"golang.org/x/text/language"
 )
 
 // Respond is used to return data to the client with a custom http code
 // The returned content-type will respect the Accept header for application/json,
-// application/cbor and application/xml (and application/html and text/html if enabled
-// with SetHTMLTemplatePaths).
+// application/cbor, application/xml, application/fhir+json, application/fhir+xml,
+// text/csv and text/tab-separated-values (and application/html and text/html if
+// enabled with SetHTMLTemplates or SetHTMLTemplatePaths).
+// CSV and TSV responses are sent as a download, see ContentTypeCSV.
+// The fields query parameter and redact struct tags decide which fields are
+// sent, see FieldsParam and WithScopes. The response can be wrapped in an
+// Envelope with metadata, see WithEnvelope. JSON and CBOR are encoded with the
+// options set with SetEncodeOptions, see RespondWithOptions, and XML as
+// described by SetXMLOptions.
 func Respond(w nh.ResponseWriter, r *nh.Request, statusCode int, response interface{}) {
-	contentType := decideAccept(r.Header) // request accept is response content-type
+	respond(w, r, statusCode, "", response, DefaultEncodeOptions())
+}
 
-	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(statusCode) // commit point. contentType and statusCode are now on the wire
-	var err error
-	switch contentType {
-	case "text/plain":
-		if response != nil {
-			fmt.Fprintf(w, "%v", response)
-		}
-	case "text/html":
-		// Return html as a complete page.
-		enc := successEncoderForHTML{htmlTemplatePath: responseTemplatePaths.TextHTML, w: w}
-		err = enc.Encode(response)
-	case "application/html":
-		// Return html as a fragment for embedding in another page.
-		enc := successEncoderForHTML{htmlTemplatePath: responseTemplatePaths.ApplicationHTML, w: w}
-		err = enc.Encode(response)
-	case "application/cbor":
-		cbor := &codec.CborHandle{}
-		enc := codec.NewEncoder(w, cbor)
-		err = enc.Encode(response)
-	case "application/json":
-		json := &codec.JsonHandle{}
-		json.Canonical = true
-		enc := codec.NewEncoder(w, json)
-		err = enc.Encode(response)
-	case "application/xml":
-		var bytes []byte
-		bytes, err = xml.Marshal(response)
-		if err == nil {
-			_, err = w.Write(bytes)
-		}
-	default:
-		panic(fmt.Sprintf("[RespondOk] unexpected Accept header: %s", contentType)) // decideAccept must ensure that this never happens
-	}
-	if err != nil {
-		log.Println("[RespondOk] Encode Error:", contentType, err)
-		// There is no point in calling RespondError() because calling w.WriteHeader(...) again
-		// will have no effect on the returned status - w.WriteHeader(...) has already been called
-		// once, implicitly, by the first w.Write(...).
-		// We could encode the response to our own buffer before calling w.WriteHeader(...) but then we
-		// lose all the performance advantages of streaming the response.
-		// So instead we prevoke the http server into breaking the connection prematurely which will
-		// result in nginx returning 502 to the caller.
-		panic(fmt.Sprintf("[RespondOk] failed to send response. Content-Type: %s. Error: %s", contentType, err.Error()))
-	}
+// RespondTemplate is Respond with the page used if the client accepts HTML,
+// which lets each handler have its own view. text/html and application/html
+// are both rendered with the page, whether or not there is a default
+// template for them. See SetHTMLTemplates.
+//
+// Respond on its own uses the page registered for the route or the type of
+// response (see RegisterRouteHTMLTemplate and RegisterHTMLTemplate), and
+// failing those the default template.
+func RespondTemplate(w nh.ResponseWriter, r *nh.Request, statusCode int, page string, response interface{}) {
+	respond(w, r, statusCode, page, response, DefaultEncodeOptions())
 }
 
 // RespondOk is used to return data to the client with a 200 http code
@@ -82,30 +54,63 @@ func RespondOk(w nh.ResponseWriter, r *nh.Request, response interface{}) {
 // application/cbor and application/xml (and application/html and text/html if enabled
 // with SetHTMLTemplatePaths).
 // Be careful in here not to recurse (by calling RespondError() again) when there is an error.
-// For the detail parameter, only error, string and RespondErrorDetail types are useful.
+// For the detail parameter, only error, string, RespondErrorDetail, ErrorDetail and ErrorCode types are useful.
+// The message is translated when the code has one for the Accept-Language, see RegisterErrorMessages.
+// In envelope mode the error is sent in the errors of an Envelope, see WithEnvelope.
 func RespondError(w nh.ResponseWriter, r *nh.Request, statusCode int, detail ...interface{}) {
 	contentType := decideAccept(r.Header) // request accept is response content-type
+	opts := requestEncodeOptions(r, DefaultEncodeOptions())
 
+	translator := newErrorTranslator(r)
 	response := ResponseError{
 		StatusCode: statusCode,
-		Message:    nh.StatusText(statusCode),
+		Code:       statusErrorCode(statusCode),
+		RequestID:  requestid.FromContext(r.Context()),
 	}
 
+	message, hasCode := "", false
 	for _, v := range detail {
 		if err, ok := v.(error); ok {
-			response.Message = err.Error()
+			message = err.Error()
 		} else if str, ok := v.(string); ok {
 			if len(str) > 0 && str[0] == '#' {
 				response.Documentation = str[1:]
 			} else {
-				response.Message = str
+				message = str
 			}
 		} else if d, ok := v.(RespondErrorDetail); ok {
 			response.Details = append(response.Details, string(d))
+		} else if d, ok := v.(ErrorDetail); ok {
+			response.Details = append(response.Details, translator.detail(d))
+		} else if c, ok := v.(ErrorCode); ok {
+			response.Code, hasCode = string(c), true
 		}
 	}
 
-	w.Header().Set("Content-Type", contentType)
+	// A translation of the code wins over the handler's message, unless the
+	// code is just the status
+	translated, lang, ok := translator.message(response.Code)
+	switch {
+	case ok && (hasCode || message == ""):
+		response.Message = translated
+	case message != "":
+		response.Message, lang = message, language.Und
+	default:
+		response.Message, lang = nh.StatusText(statusCode), language.English
+	}
+	if translator.localised() {
+		addVary(w.Header(), "Accept-Language")
+		if lang != language.Und {
+			w.Header().Set("Content-Language", lang.String())
+		}
+	}
+
+	var body interface{} = response
+	if useEnvelope(w.Header(), r, contentType) {
+		body = errorEnvelope(r, response)
+	}
+
+	w.Header().Set("Content-Type", contentTypeHeader(contentType))
 	w.WriteHeader(response.StatusCode) // commit point. contentType and StatusCode are now on the wire
 
 	var err error
@@ -113,31 +118,23 @@ func RespondError(w nh.ResponseWriter, r *nh.Request, statusCode int, detail ...
 	case "text/plain":
 		// Return plain text
 		fmt.Fprintf(w, "%v", response.Message)
-	case "text/html":
-		// Return html, as a complete page.
-		enc := errorEncoderForHTML{htmlTemplateDef: sErrorTemplateTextHTML, w: w}
-		err = enc.Encode(response)
-	case "application/html":
-		// Return html as a fragment for embedding in another page.
-		enc := errorEncoderForHTML{htmlTemplateDef: sErrorTemplateApplicationHTML, w: w}
-		err = enc.Encode(response)
-	case "application/cbor":
-		cbor := &codec.CborHandle{}
-		enc := codec.NewEncoder(w, cbor)
-		err = enc.Encode(response)
-	case "application/json":
-		json := &codec.JsonHandle{}
-		json.Canonical = true
-		enc := codec.NewEncoder(w, json)
-		err = enc.Encode(response)
-	case "application/xml":
-		var bytes []byte
-		bytes, err = xml.Marshal(response)
-		if err == nil {
-			_, err = w.Write(bytes)
-		}
+	case "text/html", "application/html":
+		// Return html as a complete page (text/html) or a fragment for
+		// embedding in another page (application/html).
+		err = renderHTMLError(w, r, contentType, response)
+	case "application/cbor", "application/json", "application/xml":
+		// Naming, OmitEmpty and TimeLayout are left out as ResponseError has
+		// to stay the same for other services to parse it
+		err = encodeResponse(w, contentType, "", body, EncodeOptions{HTMLCharsAsIs: opts.HTMLCharsAsIs, Indent: opts.Indent})
+	case ContentTypeFHIRJSON, ContentTypeFHIRXML:
+		// FHIR clients expect an OperationOutcome
+		err = encodeResponse(w, contentType, "", NewOperationOutcome(response), opts)
+	case ContentTypeCSV:
+		err = encodeCSV(w, response, ',')
+	case ContentTypeTSV:
+		err = encodeCSV(w, response, '\t')
 	default:
-		panic(fmt.Sprintf("[RespondError] unexpected Accept header: %s", contentType)) // decideAccept must guarantee that this never happens
+		panic(fmt.Sprintf("[RespondError] unexpected Accept header: %s", contentType)) // decideAccept and supportedBodyType must guarantee that this never happens
 	}
 	if err != nil {
 		log.Println("[RespondError] Encode Error:", contentType, response.Message, err)
@@ -147,21 +144,95 @@ func RespondError(w nh.ResponseWriter, r *nh.Request, statusCode int, detail ...
 		// lose all the performance advantages of streaming the response.
 		// So instead we prevoke the http server into breaking the connection prematurely which will
 		// result in nginx returning 502 to the caller.
-		panic(fmt.Sprintf("[RespondError] failed to send response. Content-Type: %s Error: %s", contentType, err.Error()))
+		panic(abortResponse(fmt.Sprintf("[RespondError] failed to send response. Content-Type: %s Error: %s", contentType, err.Error())))
 	}
 }
 
 // RespondErrorDetail allows a RespondError detail parameter to end up in the Details array
 type RespondErrorDetail string
 
+// abortResponse is what Respond and RespondError panic with when they have to
+// break the connection, so the Recovery middleware can tell it apart from a bug
+type abortResponse string
+
+func (a abortResponse) Error() string {
+	return string(a)
+}
+
 //////////////////////////////////////////////////////////////////////////
 // Implementation
 
-// responseTemplatePaths contains the paths to HTML template files for formatting html responses.
-// Usually only used for testing.
-var responseTemplatePaths struct {
-	ApplicationHTML string
-	TextHTML        string
+// respond is Respond with the HTML page chosen by the handler, "" if it didn't
+// choose, and the options to encode with
+func respond(w nh.ResponseWriter, r *nh.Request, statusCode int, page string, response interface{}, opts EncodeOptions) {
+	contentType, page := negotiateResponse(r, page, response) // request accept is response content-type
+	opts = requestEncodeOptions(r, opts)
+	opts.root = xmlTypeName(reflect.TypeOf(response))
+	response = shapeResponse(r, contentType, response, opts)
+	if useEnvelope(w.Header(), r, contentType) {
+		response = envelope(r, response)
+	}
+	if contentType == ContentTypeFHIRXML {
+		if v := indirect(reflect.ValueOf(response)); v.IsValid() {
+			if _, err := fhirXMLRoot(v, opts); err != nil {
+				log.Println("[RespondOk] Encode Error:", contentType, err)
+				RespondError(w, r, nh.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeHeader(contentType))
+	setContentDisposition(w.Header(), r, contentType)
+	w.WriteHeader(statusCode) // commit point. contentType and statusCode are now on the wire
+	err := encodeResponse(w, contentType, page, response, opts)
+	if err != nil {
+		log.Println("[RespondOk] Encode Error:", contentType, err)
+		// There is no point in calling RespondError() because calling w.WriteHeader(...) again
+		// will have no effect on the returned status - w.WriteHeader(...) has already been called
+		// once, implicitly, by the first w.Write(...).
+		// We could encode the response to our own buffer before calling w.WriteHeader(...) but then we
+		// lose all the performance advantages of streaming the response.
+		// So instead we prevoke the http server into breaking the connection prematurely which will
+		// result in nginx returning 502 to the caller.
+		panic(abortResponse(fmt.Sprintf("[RespondOk] failed to send response. Content-Type: %s. Error: %s", contentType, err.Error())))
+	}
+}
+
+// encodeResponse writes a success response body in the negotiated content type.
+// Shared by Respond and the helpers that need the encoded body before deciding
+// what to send (e.g. RespondCached). page is the HTML template, "" for the default.
+// response must already be shaped with the same opts.
+func encodeResponse(w io.Writer, contentType, page string, response interface{}, opts EncodeOptions) error {
+	var err error
+	switch contentType {
+	case "text/plain":
+		if response != nil {
+			fmt.Fprintf(w, "%v", response)
+		}
+	case "text/html", "application/html":
+		// Return html as a complete page (text/html) or a fragment for
+		// embedding in another page (application/html).
+		if page == "" {
+			page = defaultHTMLTemplate(contentType)
+		}
+		err = renderHTML(w, page, response)
+	case "application/cbor":
+		err = cborCodec.encode(w, response)
+	case "application/json", ContentTypeFHIRJSON:
+		err = jsonCodec(opts).encode(w, response)
+	case "application/xml":
+		err = encodeXML(w, response, opts)
+	case ContentTypeFHIRXML:
+		err = encodeFHIRXML(w, response, opts)
+	case ContentTypeCSV:
+		err = encodeCSV(w, response, ',')
+	case ContentTypeTSV:
+		err = encodeCSV(w, response, '\t')
+	default:
+		panic(fmt.Sprintf("[RespondOk] unexpected Accept header: %s", contentType)) // decideAccept must ensure that this never happens
+	}
+	return err
 }
 
 func cleanSentenceJoin(l, r string) string {
@@ -176,81 +247,103 @@ func cleanSentenceJoin(l, r string) string {
 
 // ResponseError structure holds standard fields for errors.
 // Public so that when two of our own applications communicate; one can parse the error received from the other.
+// In XML the root element is <ResponseError>, and each error in an Envelope is an <error>.
 type ResponseError struct {
 	StatusCode    int      `json:"status_code"`
+	Code          string   `json:"code,omitempty"`
 	Message       string   `json:"message"`
 	Documentation string   `json:"documentation"`
 	Details       []string `json:"details,omitempty"`
+	RequestID     string   `json:"request_id,omitempty"`
 }
 
-// decideContentType provides consistent handling of the content-type header value.
-func decideContentType(requestHeader nh.Header) string {
-	return decideBodyType(requestHeader.Get("Content-Type"), false)
+// decideContentType provides consistent handling of the content-type header
+// value of a request body, returning the type to decode it as (JSON if there
+// is no header) and its charset. The error wraps ErrUnsupportedMediaType if it
+// can't be decoded.
+func decideContentType(requestHeader nh.Header) (string, string, error) {
+	header := requestHeader.Get("Content-Type")
+	if strings.TrimSpace(header) == "" {
+		return "application/json", "", nil
+	}
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrUnsupportedMediaType, header)
+	}
+	contentType, ok := supportedBodyType(mediaType, false)
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	return contentType, params["charset"], nil
 }
 
 // decideAccept provides consistent handling of the accept header value.
 func decideAccept(requestHeader nh.Header) string {
-	return decideBodyType(requestHeader.Get("Accept"), true)
-}
-
-// decideBodyType provides consistent handling of content-type and accept header values.
-// Also consistently handles accept types that depend on HTML templates (which may not exist).
-// Also consistently handles FHIR aliasing - we treat fhir-foo as foo.
-func decideBodyType(headerValue string, isResponse bool) string {
-
-	// Can only return HTML if the relevant template exists.
-	// We want the same response type choice to occur for errors as for success.
-	switch headerValue {
-	case "text/plain":
-		if isResponse {
-			return headerValue
-		}
-	case "text/html":
-		if isResponse && responseTemplatePaths.TextHTML != "" {
-			return headerValue
-		}
-	case "application/html":
-		if isResponse && responseTemplatePaths.ApplicationHTML != "" {
-			return headerValue
-		}
-	case "application/cbor":
-		return headerValue
-	case "", "application/json", "application/fhir+json":
-		return "application/json"
-	case "application/xml", "application/fhir+xml":
-		return "application/xml"
-	case "application/fail": // for testing panic
-		return headerValue
+	mediaType := strings.TrimSpace(requestHeader.Get("Accept"))
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = parsed
+	}
+	if contentType, ok := supportedBodyType(mediaType, true); ok {
+		return contentType
 	}
-
 	// Force default behaviour
 	return "application/json"
 }
 
-type successEncoderForHTML struct {
-	htmlTemplatePath string
-	w                nh.ResponseWriter
+// acceptHTML returns the HTML type in the accept header, if that is what it is
+func acceptHTML(requestHeader nh.Header) string {
+	mediaType, _, _ := mime.ParseMediaType(requestHeader.Get("Accept"))
+	if mediaType == "text/html" || mediaType == "application/html" {
+		return mediaType
+	}
+	return ""
 }
 
-func (encoder successEncoderForHTML) Encode(response interface{}) error {
-	_, filename := filepath.Split(encoder.htmlTemplatePath)
-
-	// Use text.template to avoid escaping the response which assumed to already be html
-	t, err := texttemplate.New(filename).Funcs(texttemplate.FuncMap{
-		"fieldExists": fieldExists,
-	}).ParseFiles(encoder.htmlTemplatePath)
-	if err != nil {
-		return err
+// supportedBodyType provides consistent handling of content-type and accept
+// header values, mapping a media type without parameters onto the type sent
+// (isResponse) or decoded for it. False if it isn't supported.
+// Also consistently handles accept types that depend on HTML templates (which may not exist).
+// The DSTU2 FHIR types (json+fhir, xml+fhir) are treated as the current ones.
+// Request bodies in text/xml or a type with a +json, +xml or +cbor suffix
+// (RFC 6839) are decoded as their base format, e.g. application/problem+json
+// as JSON. Responses don't use the suffixes, so a browser's
+// application/xhtml+xml doesn't get XML.
+func supportedBodyType(mediaType string, isResponse bool) (string, bool) {
+	// Can only return HTML if the relevant template exists.
+	// We want the same response type choice to occur for errors as for success.
+	switch mediaType {
+	case "text/plain", ContentTypeCSV, ContentTypeTSV:
+		return mediaType, isResponse
+	case "text/html", "application/html":
+		return mediaType, isResponse && defaultHTMLTemplate(mediaType) != ""
+	case "application/cbor":
+		return mediaType, true
+	case "application/json":
+		return "application/json", true
+	case "*/*", "application/*":
+		return "application/json", isResponse
+	case "application/xml":
+		return "application/xml", true
+	case "text/xml":
+		return "application/xml", !isResponse
+	case ContentTypeFHIRJSON, "application/json+fhir":
+		return ContentTypeFHIRJSON, true
+	case ContentTypeFHIRXML, "application/xml+fhir":
+		return ContentTypeFHIRXML, true
+	case "application/fail": // for testing panic
+		return mediaType, isResponse
 	}
 
-	var out bytes.Buffer
-	err = t.Execute(&out, &response)
-	if err != nil {
-		return err
+	switch {
+	case isResponse:
+	case strings.HasSuffix(mediaType, "+json"):
+		return "application/json", true
+	case strings.HasSuffix(mediaType, "+xml"):
+		return "application/xml", true
+	case strings.HasSuffix(mediaType, "+cbor"):
+		return "application/cbor", true
 	}
-
-	encoder.w.Write([]byte(out.String()))
-	return nil
+	return "", false
 }
 
 func fieldExists(name string, data interface{}) bool {
@@ -268,68 +361,3 @@ func fieldExists(name string, data interface{}) bool {
 	}
 	return v.FieldByName(name).IsValid()
 }
-
-type errorEncoderForHTML struct {
-	htmlTemplateDef string
-	w               nh.ResponseWriter
-}
-
-func (encoder errorEncoderForHTML) Encode(response ResponseError) error {
-	// Use text.template to avoid escaping the response which is already html
-	// Be careful in here not to recurse (by calling RespondError() again) when there is an error.
-	t, err := texttemplate.New("ErrorOccured").Parse(encoder.htmlTemplateDef)
-	if err != nil {
-		return err
-	}
-
-	response.Message = prepareStringAsHTML(response.Message)
-	response.Documentation = prepareStringAsHTML(response.Documentation)
-
-	var out bytes.Buffer
-	err = t.Execute(&out, &response)
-	if err != nil {
-		return err
-	}
-
-	encoder.w.Write([]byte(out.String()))
-	return nil
-}
-
-func prepareStringAsHTML(s string) string {
-	return strings.Replace(strings.Replace(strings.TrimSpace(s), "\n", "<br/>", -1), "\r", "", -1)
-}
-
-const sErrorTemplateApplicationHTML = `<table>
-<tr><td>StatusCode:</td><td id="errorcode">{{.StatusCode}}</td></tr>
-<tr><td>Message:</td><td id="errormessage">{{.Message}}</td></tr>
-<tr><td>Documentation:</td><td id="errordocumentation">{{.Documentation}}</td></tr>
-</table>`
-
-const sErrorTemplateTextHTML = `<!DOCTYPE html>
-<html lang="en">
-<head>
-<title>An error occured</title>
-<style>
-table, tr, td {
-	border: 1px solid black;
-	border-collapse: collapse;
-}
-#errormessage {
-	font-weight: bold;
-}
-</style>
-</head>
-<body>` + sErrorTemplateApplicationHTML + `
-</body>
-</html>
-`
-
-// SetHTMLTemplatePaths allows the application to specify the paths to HTML tempate
-// files that will be used for responses with HTML content-types.
-// If a template path is empty, it will not be used and the default content-type
-// (application/json) will be used instead.
-// Template paths are empty by default.
-func SetHTMLTemplatePaths(applicationHTML, textHTML string) {
-	responseTemplatePaths.ApplicationHTML = applicationHTML
-	responseTemplatePaths.TextHTML = textHTML
-}
@@ -4,9 +4,6 @@ import (
 	// Core packages
 	"encoding/json"
 	basehttp "net/http"
-	"os"
-	"regexp"
-	"time"
 
 	// DocHQ specific packages
 	"github.com/DocHQ/logging"
@@ -18,52 +15,38 @@ import (
 // Simple cover for the mux router, saves another import at the service level
 type Router struct {
 	*mux.Router
+
+	recovery RecoveryOptions
 }
 
 // A copy of the router for internal passing betweeen functions
 var r *Router
 
+// Option turns on optional behaviour for a Router created by New
+type Option func(*Router)
+
 // function New is just your normal new function for a HTTP router, this allows
 // the calling applicaiton to add routes specific to that microservice
-func New() *Router {
+func New(opts ...Option) *Router {
 	// Create a new instance of the router interface to be passed back to the caller
 	var r *Router = &Router{}
 	r.Router = mux.NewRouter() // this init's some internal stuff so can't from outside
 
-	// Create a default logging middleware layer that tells us every htto request going
-	// through the http server,
-	r.Use(func(next basehttp.Handler) basehttp.Handler {
-		return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
-			start := time.Now()
-			path := r.RequestURI
-			sw := statusWriter{ResponseWriter: w}
+	// Every request gets a correlation id before anything else happens so it
+	// is available to the logs and error responses below
+	r.Use(RequestID)
 
-			next.ServeHTTP(&sw, r)
+	// Server span per request, a no-op unless the service calls tracing.Init
+	r.Use(Tracing)
 
-			// Where Google spams the /health endpoint constantly,
-			// skip it in the logs
-			if path == "/health" && os.Getenv("DEBUG_WITH_HEALTH") != "true" {
-				return
-			}
+	// Create a default logging middleware layer that tells us every http request going
+	// through the http server, see accesslog.go for the format and sinks
+	r.Use(accessLogMiddleware)
 
-			header := ""
-			var re = regexp.MustCompile(`(?m).*\s(.*)$`)
-			header = re.ReplaceAllString(r.Header.Get("Authorization"), " Token:$1")
-
-			end := time.Now()
-			latency := end.Sub(start)
-
-			logging.Infof(
-				"HTTP Request :- time:%v ip:%v latency:%v method:%v path:%v status:%v%v",
-				end.Format(time.RFC3339),
-				r.RemoteAddr,
-				latency,
-				r.Method,
-				path,
-				sw.status,
-				header,
-			)
-		})
+	// Turn handler panics into 500s, inside the logging so they are recorded
+	// with the right status
+	r.Use(func(next basehttp.Handler) basehttp.Handler {
+		return recoveryHandler(next, &r.recovery)
 	})
 
 	// Due to angular being a thing, we need to make sure we respond correctly
@@ -91,6 +74,17 @@ func New() *Router {
 		}
 	}).Methods("GET")
 
+	// Liveness and readiness for the orchestrator, readiness runs the checks
+	// added with RegisterHealthCheck, see health.go
+	r.HandleFunc("/health/live", healthLiveHandler).Methods("GET")
+	r.HandleFunc("/health/ready", healthReadyHandler).Methods("GET")
+
+	// Options are applied last so any middleware they add runs inside the
+	// defaults above
+	for _, opt := range opts {
+		opt(r)
+	}
+
 	// Return the resulting router
 	return r
 }
@@ -110,7 +104,7 @@ func Authorize(serviceKey string) (mw func(basehttp.Handler) basehttp.Handler) {
 				return
 			}
 
-			if r.URL.Path == "/health" {
+			if isHealthPath(r.URL.Path) || isPublicRoute(r) {
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
@@ -5,12 +5,107 @@ import (
 	base_http "net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/DocHQ/helpers/internal/env"
 	"github.com/DocHQ/logging"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Defaults used by NewServer when neither the environment nor an option
+// provides a value
+const (
+	DefaultAddr              = ":8080"
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 15 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultMaxHeaderBytes    = 1 << 20
 )
 
+// ServerOption changes the server built by NewServer, options are applied
+// after the environment so they always win
+type ServerOption func(*base_http.Server)
+
+// WithAddr sets the address to listen on, e.g. ":5000"
+func WithAddr(addr string) ServerOption {
+	return func(srv *base_http.Server) { srv.Addr = addr }
+}
+
+// WithReadHeaderTimeout sets how long a client has to send the request headers
+func WithReadHeaderTimeout(d time.Duration) ServerOption {
+	return func(srv *base_http.Server) { srv.ReadHeaderTimeout = d }
+}
+
+// WithReadTimeout sets how long a client has to send the whole request
+func WithReadTimeout(d time.Duration) ServerOption {
+	return func(srv *base_http.Server) { srv.ReadTimeout = d }
+}
+
+// WithWriteTimeout sets how long a handler has to write the response.
+// RespondStream lifts it for its streams (from Go 1.20), but a large CSV
+// export or any other long response sent with Respond is cut off when it
+// runs out, so raise it or use 0 for servers that send those.
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(srv *base_http.Server) { srv.WriteTimeout = d }
+}
+
+// WithIdleTimeout sets how long keep-alive connections are held open between requests
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(srv *base_http.Server) { srv.IdleTimeout = d }
+}
+
+// WithMaxHeaderBytes sets the maximum size of the request headers
+func WithMaxHeaderBytes(n int) ServerOption {
+	return func(srv *base_http.Server) { srv.MaxHeaderBytes = n }
+}
+
+// NewServer builds a http.Server for the router with hardened defaults, ready
+// to be passed to StartServer.
+//
+// The defaults can be overridden from the environment (a .env file is loaded by
+// the godotenv autoload import):
+//
+//	PORT                      port to listen on, e.g. 5000
+//	HTTP_ADDR                 full listen address, takes precedence over PORT
+//	HTTP_READ_HEADER_TIMEOUT  e.g. 5s
+//	HTTP_READ_TIMEOUT         e.g. 15s
+//	HTTP_WRITE_TIMEOUT        e.g. 30s, 0 for none
+//	HTTP_IDLE_TIMEOUT         e.g. 2m
+//	HTTP_MAX_HEADER_BYTES     e.g. 1048576
+//
+// and then by any options passed in.
+func NewServer(router base_http.Handler, opts ...ServerOption) *base_http.Server {
+	srv := &base_http.Server{
+		Handler:           router,
+		Addr:              DefaultAddr,
+		ReadHeaderTimeout: env.Duration("HTTP_READ_HEADER_TIMEOUT", DefaultReadHeaderTimeout),
+		ReadTimeout:       env.Duration("HTTP_READ_TIMEOUT", DefaultReadTimeout),
+		WriteTimeout:      env.Duration("HTTP_WRITE_TIMEOUT", DefaultWriteTimeout),
+		IdleTimeout:       env.Duration("HTTP_IDLE_TIMEOUT", DefaultIdleTimeout),
+		MaxHeaderBytes:    env.Int("HTTP_MAX_HEADER_BYTES", DefaultMaxHeaderBytes),
+	}
+
+	if port := os.Getenv("PORT"); port != "" {
+		srv.Addr = ":" + port
+	}
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		srv.Addr = addr
+	}
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	return srv
+}
+
+// StartServer runs srv until SIGINT or SIGTERM, then shuts it down gracefully.
+// It reads HTTP_SHUTDOWN_DELAY, how long to keep serving with /health/ready
+// failing before shutting down, 0 by default.
 func StartServer(srv *base_http.Server) {
 	// Allow graceful exit by listening for terminate signal
 	stop := make(chan os.Signal, 1)
@@ -35,6 +130,14 @@ CronLoop:
 		select {
 		case <-stop:
 			{
+				// Fail readiness first and give the load balancer a chance to
+				// notice before we stop accepting connections
+				atomic.StoreInt32(&shuttingDown, 1)
+				if delay := env.Duration("HTTP_SHUTDOWN_DELAY", 0); delay > 0 {
+					logging.Infof("Server will shut down in %v...", delay)
+					time.Sleep(delay)
+				}
+
 				logging.Info("Server is being shut down...")
 				ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
 				defer cancelFn()
@@ -0,0 +1,736 @@
+package http
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	nh "net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DocHQ/logging"
+
+	"github.com/ugorji/go/codec"
+)
+
+// FieldsParam is the query parameter clients use to ask for only some fields,
+// e.g. ?fields=id,name,address.city. Names are the json names and nested
+// fields are separated by dots. For a Page the fields apply to the items.
+// HTML and text/plain responses ignore it as templates and String methods
+// expect the whole value.
+const FieldsParam = "fields"
+
+// RedactedValue replaces the value of fields masked by a redact tag
+const RedactedValue = "****"
+
+// WithScopes returns a copy of ctx with scopes added to the caller's scopes.
+// Authentication middleware sets them, and Respond uses them to decide which
+// fields tagged with redact the caller may see:
+//
+//	type Patient struct {
+//		Name string    `json:"name"`
+//		NHS  string    `json:"nhs_number" redact:"pii"`
+//		DOB  time.Time `json:"dob" redact:"pii clinical,mask"`
+//	}
+//
+// A field is only sent to callers with one of the space separated scopes in
+// its tag. Otherwise it is left out, or with ",mask" sent as RedactedValue
+// so clients can see it exists. HTML templates and text/plain responses get
+// values of the original types, so templates and String methods still work,
+// with the hidden strings set to RedactedValue and other hidden fields left
+// empty. Types with their own
+// marshalling methods are sent as they are, redact tags inside them are
+// ignored and logged as an error.
+func WithScopes(ctx context.Context, scopes ...string) context.Context {
+	all := append(append([]string{}, Scopes(ctx)...), scopes...)
+	return context.WithValue(ctx, scopesKey{}, all)
+}
+
+// Scopes returns the caller's scopes set by WithScopes
+func Scopes(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesKey{}).([]string)
+	return scopes
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+//
+// Shaping makes a copy of the response using types built with
+// reflect.StructOf that only have the fields to send, so every encoder sees
+// the same thing. Types that don't change are used as they are. For HTML the
+// copy keeps the original types and only the hidden fields are cleared.
+
+type scopesKey struct{}
+
+// fieldTree is the parsed fields parameter, a nil subtree means the whole field
+type fieldTree map[string]fieldTree
+
+func parseFields(param string) fieldTree {
+	if strings.TrimSpace(param) == "" {
+		return nil
+	}
+	tree := fieldTree{}
+	for _, path := range strings.Split(param, ",") {
+		node := tree
+		parts := strings.Split(strings.TrimSpace(path), ".")
+		for i, name := range parts {
+			if name == "" {
+				break
+			}
+			sub, seen := node[name]
+			if seen && sub == nil {
+				break // already the whole field
+			}
+			if i == len(parts)-1 {
+				node[name] = nil
+				break
+			}
+			if sub == nil {
+				sub = fieldTree{}
+				node[name] = sub
+			}
+			node = sub
+		}
+	}
+	return tree
+}
+
+// shapeResponse applies the fields parameter, redact tags and the EncodeOptions
+// that change types to a response
+func shapeResponse(r *nh.Request, contentType string, response interface{}, opts EncodeOptions) interface{} {
+	return newShaper(r, contentType, opts).shape(response)
+}
+
+// shaper holds the plans for one set of scopes, fields and options
+type shaper struct {
+	tree   fieldTree
+	scopes map[string]bool
+	opts   shapeOptions
+
+	// keepTypes keeps the original types, as templates and String methods
+	// need their methods
+	keepTypes bool
+
+	mu    sync.Mutex
+	plans map[shapeKey]*shapePlan
+}
+
+type shapeKey struct {
+	t    reflect.Type
+	tree uintptr
+}
+
+// shapeOptions are the EncodeOptions done by shaping, as the codec package
+// can't do them
+type shapeOptions struct {
+	naming     NamingPolicy
+	omitEmpty  bool
+	timeLayout string
+}
+
+// shapers are reused when there is no fields parameter, as then the plans
+// only depend on the scopes and options, which the application controls
+var shapers sync.Map
+
+func newShaper(r *nh.Request, contentType string, opts EncodeOptions) *shaper {
+	keepTypes := contentType == "text/html" || contentType == "application/html" || contentType == "text/plain"
+	scopes := Scopes(r.Context())
+	var shapeOpts shapeOptions
+	if codecContentType(contentType) {
+		shapeOpts = shapeOptions{naming: opts.Naming, omitEmpty: opts.OmitEmpty, timeLayout: opts.TimeLayout}
+	}
+
+	var tree fieldTree
+	if !keepTypes {
+		tree = parseFields(r.URL.Query().Get(FieldsParam))
+	}
+	if tree == nil {
+		sorted := append([]string{}, scopes...)
+		sort.Strings(sorted)
+		key := strings.Join(sorted, " ")
+		if keepTypes {
+			key += "|keep"
+		}
+		if shapeOpts != (shapeOptions{}) {
+			key += fmt.Sprintf("|%d|%t|%s", shapeOpts.naming, shapeOpts.omitEmpty, shapeOpts.timeLayout)
+		}
+		if s, ok := shapers.Load(key); ok {
+			return s.(*shaper)
+		}
+		s, _ := shapers.LoadOrStore(key, makeShaper(nil, scopes, keepTypes, shapeOpts))
+		return s.(*shaper)
+	}
+	return makeShaper(tree, scopes, keepTypes, shapeOpts)
+}
+
+func makeShaper(tree fieldTree, scopes []string, keepTypes bool, opts shapeOptions) *shaper {
+	s := &shaper{tree: tree, scopes: map[string]bool{}, keepTypes: keepTypes, opts: opts, plans: map[shapeKey]*shapePlan{}}
+	for _, scope := range scopes {
+		s.scopes[scope] = true
+	}
+	return s
+}
+
+func (s *shaper) shape(response interface{}) interface{} {
+	if response == nil {
+		return nil
+	}
+	if page, ok := response.(Page); ok {
+		page.Items = s.shape(page.Items)
+		return page
+	}
+
+	v := reflect.ValueOf(response)
+	s.mu.Lock()
+	p := s.plan(v.Type(), s.tree)
+	s.mu.Unlock()
+	if p.identity {
+		return response
+	}
+	return p.conv(v).Interface()
+}
+
+// shapePlan converts values of one type into the shaped type out
+type shapePlan struct {
+	out        reflect.Type
+	identity   bool
+	conv       func(reflect.Value) reflect.Value
+	inProgress bool
+}
+
+var (
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	stringType         = reflect.TypeOf("")
+	xmlNameType        = reflect.TypeOf(xml.Name{})
+	marshalerTypes     = []reflect.Type{
+		reflect.TypeOf((*json.Marshaler)(nil)).Elem(),
+		reflect.TypeOf((*xml.Marshaler)(nil)).Elem(),
+		reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem(),
+		reflect.TypeOf((*codec.Selfer)(nil)).Elem(),
+	}
+)
+
+var identityPlan = &shapePlan{identity: true, conv: func(v reflect.Value) reflect.Value { return v }}
+
+// opaque types marshal themselves so can't be shaped
+func opaque(t reflect.Type) bool {
+	for _, m := range marshalerTypes {
+		if t.Implements(m) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// plan must be called with s.mu held
+func (s *shaper) plan(t reflect.Type, tree fieldTree) *shapePlan {
+	key := shapeKey{t: t, tree: reflect.ValueOf(tree).Pointer()}
+	if p, ok := s.plans[key]; ok {
+		if p.inProgress {
+			return s.recursivePlan(key)
+		}
+		return p
+	}
+	p := &shapePlan{inProgress: true}
+	s.plans[key] = p
+	*p = *s.build(t, tree)
+	p.inProgress = false
+	if p.identity {
+		p.out = t
+	}
+	return p
+}
+
+// recursivePlan is used where a type contains itself, which StructOf can't
+// express, so the field becomes an interface{} holding the shaped value
+func (s *shaper) recursivePlan(key shapeKey) *shapePlan {
+	if s.keepTypes {
+		// The type stays the same, so just use the finished plan
+		return &shapePlan{out: key.t, conv: func(v reflect.Value) reflect.Value {
+			s.mu.Lock()
+			p := s.plans[key]
+			s.mu.Unlock()
+			return p.conv(v)
+		}}
+	}
+	return &shapePlan{
+		out: emptyInterfaceType,
+		conv: func(v reflect.Value) reflect.Value {
+			s.mu.Lock()
+			p := s.plans[key]
+			s.mu.Unlock()
+			out := reflect.New(emptyInterfaceType).Elem()
+			if shaped := p.conv(v); shaped.IsValid() {
+				out.Set(shaped)
+			}
+			return out
+		},
+	}
+}
+
+func (s *shaper) build(t reflect.Type, tree fieldTree) *shapePlan {
+	if t == timeType && s.opts.timeLayout != "" {
+		return &shapePlan{out: stringType, conv: func(v reflect.Value) reflect.Value {
+			tm := v.Interface().(time.Time)
+			if tm.IsZero() && s.opts.omitEmpty {
+				return reflect.ValueOf("")
+			}
+			return reflect.ValueOf(tm.Format(s.opts.timeLayout))
+		}}
+	}
+	if opaque(t) {
+		checkOpaqueRedaction(t)
+		return identityPlan
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := s.plan(t.Elem(), tree)
+		if elem.identity {
+			return identityPlan
+		}
+		out := reflect.PtrTo(elem.out)
+		if elem.out == t.Elem() {
+			out = t
+		}
+		return &shapePlan{out: out, conv: func(v reflect.Value) reflect.Value {
+			if v.IsNil() {
+				return reflect.Zero(out)
+			}
+			n := reflect.New(elem.out)
+			n.Elem().Set(elem.conv(v.Elem()))
+			return n
+		}}
+
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return identityPlan
+		}
+		return &shapePlan{out: t, conv: func(v reflect.Value) reflect.Value {
+			out := reflect.New(t).Elem()
+			if v.IsNil() {
+				return out
+			}
+			inner := v.Elem()
+			s.mu.Lock()
+			p := s.plan(inner.Type(), tree)
+			s.mu.Unlock()
+			out.Set(p.conv(inner))
+			return out
+		}}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return identityPlan
+		}
+		elem := s.plan(t.Elem(), tree)
+		if elem.identity {
+			return identityPlan
+		}
+		if t.Kind() == reflect.Array {
+			out := reflect.ArrayOf(t.Len(), elem.out)
+			if elem.out == t.Elem() {
+				out = t
+			}
+			return &shapePlan{out: out, conv: func(v reflect.Value) reflect.Value {
+				n := reflect.New(out).Elem()
+				for i := 0; i < v.Len(); i++ {
+					n.Index(i).Set(elem.conv(v.Index(i)))
+				}
+				return n
+			}}
+		}
+		// Named types are kept when the elements are, for their methods
+		out := reflect.SliceOf(elem.out)
+		if elem.out == t.Elem() {
+			out = t
+		}
+		return &shapePlan{out: out, conv: func(v reflect.Value) reflect.Value {
+			if v.IsNil() {
+				return reflect.Zero(out)
+			}
+			n := reflect.MakeSlice(out, v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				n.Index(i).Set(elem.conv(v.Index(i)))
+			}
+			return n
+		}}
+
+	case reflect.Map:
+		// Fields select keys, nested fields only reach into interface{} values
+		filter := tree != nil && t.Key().Kind() == reflect.String
+		elem := s.plan(t.Elem(), nil)
+		if elem.identity && !filter {
+			return identityPlan
+		}
+		// Shaped values are held as interface{}, the codec package can't
+		// encode maps of types made with StructOf
+		out := t
+		if elem.out != t.Elem() {
+			out = reflect.MapOf(t.Key(), emptyInterfaceType)
+		}
+		return &shapePlan{out: out, conv: func(v reflect.Value) reflect.Value {
+			if v.IsNil() {
+				return reflect.Zero(out)
+			}
+			n := reflect.MakeMapWithSize(out, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				value := elem.conv(iter.Value())
+				if filter {
+					sub, ok := tree[iter.Key().String()]
+					if !ok {
+						continue
+					}
+					if sub != nil && t.Elem() == emptyInterfaceType && !iter.Value().IsNil() {
+						inner := iter.Value().Elem()
+						s.mu.Lock()
+						p := s.plan(inner.Type(), sub)
+						s.mu.Unlock()
+						value = reflect.New(emptyInterfaceType).Elem()
+						value.Set(p.conv(inner))
+					}
+				}
+				n.SetMapIndex(iter.Key(), value)
+			}
+			return n
+		}}
+
+	case reflect.Struct:
+		if s.keepTypes {
+			return s.buildTypedStruct(t)
+		}
+		return s.buildStruct(t, tree)
+	}
+	return identityPlan
+}
+
+type shapeField struct {
+	index  []int
+	plan   *shapePlan
+	masked bool
+}
+
+func (s *shaper) buildStruct(t reflect.Type, tree fieldTree) *shapePlan {
+	type candidate struct {
+		field    reflect.StructField
+		index    []int
+		jsonName string
+	}
+	var candidates []candidate
+	var names []namedField
+
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fieldIndex := append(append([]int{}, index...), i)
+			jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+
+			// Embedded structs are flattened, as the encoders do
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			// None of these change what is sent, so they only matter if
+			// something else means the type is rebuilt
+			if f.Anonymous && jsonName == "" && ft.Kind() == reflect.Struct && !opaque(f.Type) {
+				walk(ft, fieldIndex)
+				continue
+			}
+			if f.PkgPath != "" {
+				continue // unexported fields aren't encoded anyway
+			}
+
+			// Fields tagged "-" aren't sent, they are only kept for XMLName
+			name := jsonName
+			switch name {
+			case "":
+				name = fieldName(f.Name, s.opts.naming)
+			case "-":
+				name = "-" + f.Name
+			}
+			candidates = append(candidates, candidate{field: f, index: fieldIndex, jsonName: jsonName})
+			names = append(names, namedField{name: name, depth: len(fieldIndex), tagged: jsonName != "" && jsonName != "-"})
+		}
+	}
+	walk(t, nil)
+
+	// Fields hidden by others with the same name are left out. The codec
+	// package keeps the first of fields at the same depth rather than
+	// following encoding/json, so the type is rebuilt when there are any.
+	keep, ties := dominantFields(names)
+	var fields []reflect.StructField
+	var shaped []shapeField
+	changed := ties
+	goNames := map[string]bool{}
+	for i, c := range candidates {
+		if !keep[i] {
+			continue
+		}
+		f, fieldIndex, jsonName := c.field, c.index, c.jsonName
+		name := jsonName
+		if name == "" {
+			name = fieldName(f.Name, s.opts.naming)
+		}
+		var sub fieldTree
+		if tree != nil && !(f.Type == xmlNameType && f.Name == "XMLName") {
+			var ok bool
+			if sub, ok = tree[name]; !ok || jsonName == "-" {
+				changed = true
+				continue
+			}
+		}
+
+		tag := s.fieldTag(f)
+		if tag != f.Tag {
+			changed = true
+		}
+
+		// An embedded field can share its Go name with another that has a
+		// different json name, both are sent. The tag keeps its name.
+		goName := f.Name
+		if goNames[goName] {
+			goName += "_" + strconv.Itoa(i)
+			tag = namedTag(tag, name)
+		}
+		goNames[goName] = true
+
+		if redact := f.Tag.Get("redact"); redact != "" && !s.allowed(redact) {
+			changed = true
+			if strings.HasSuffix(redact, ",mask") {
+				fields = append(fields, reflect.StructField{Name: goName, Type: stringType, Tag: tag})
+				shaped = append(shaped, shapeField{index: fieldIndex, masked: true})
+			}
+			continue
+		}
+
+		p := s.plan(f.Type, sub)
+		if !p.identity {
+			changed = true
+		}
+		fields = append(fields, reflect.StructField{Name: goName, Type: p.out, Tag: tag})
+		shaped = append(shaped, shapeField{index: fieldIndex, plan: p})
+	}
+
+	if !changed {
+		return identityPlan
+	}
+
+	out := reflect.StructOf(fields)
+	return &shapePlan{out: out, conv: func(v reflect.Value) reflect.Value {
+		n := reflect.New(out).Elem()
+		for i, f := range shaped {
+			if f.masked {
+				n.Field(i).SetString(RedactedValue)
+				continue
+			}
+			if src := fieldByIndex(v, f.index); src.IsValid() {
+				n.Field(i).Set(f.plan.conv(src))
+			}
+		}
+		return n
+	}}
+}
+
+// buildTypedStruct copies a struct clearing the fields the caller can't see,
+// keeping its type so templates and String methods can call its methods
+func (s *shaper) buildTypedStruct(t reflect.Type) *shapePlan {
+	type change struct {
+		index  int
+		plan   *shapePlan
+		hidden bool
+	}
+	var changes []change
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // can't be set, and templates can't read it anyway
+		}
+		if redact := f.Tag.Get("redact"); redact != "" && !s.allowed(redact) {
+			changes = append(changes, change{index: i, hidden: true})
+			continue
+		}
+		if p := s.plan(f.Type, nil); !p.identity {
+			changes = append(changes, change{index: i, plan: p})
+		}
+	}
+	if len(changes) == 0 {
+		return identityPlan
+	}
+
+	return &shapePlan{out: t, conv: func(v reflect.Value) reflect.Value {
+		n := reflect.New(t).Elem()
+		n.Set(v)
+		for _, c := range changes {
+			field := n.Field(c.index)
+			switch {
+			case !c.hidden:
+				field.Set(c.plan.conv(v.Field(c.index)))
+			case field.Kind() == reflect.String:
+				field.SetString(RedactedValue)
+			default:
+				field.Set(reflect.Zero(field.Type()))
+			}
+		}
+		return n
+	}}
+}
+
+// namedField is a struct field for dominantFields, by the name it is sent as
+type namedField struct {
+	name   string
+	depth  int
+	tagged bool
+}
+
+// dominantFields applies encoding/json's rule to fields with the same name
+// once embedded structs are flattened: the shallowest wins, and of several
+// at the same depth the one with the name in its tag, otherwise none of them.
+// It reports which fields are kept, and whether any were left out for
+// having the same name and depth as another.
+func dominantFields(fields []namedField) ([]bool, bool) {
+	type rank struct {
+		depth, count, tagged int
+	}
+	ranks := map[string]*rank{}
+	for _, f := range fields {
+		r, ok := ranks[f.name]
+		if !ok || f.depth < r.depth {
+			r = &rank{depth: f.depth}
+			ranks[f.name] = r
+		}
+		if f.depth == r.depth {
+			r.count++
+			if f.tagged {
+				r.tagged++
+			}
+		}
+	}
+
+	keep := make([]bool, len(fields))
+	ties := false
+	for i, f := range fields {
+		r := ranks[f.name]
+		keep[i] = f.depth == r.depth && (r.count == 1 || (f.tagged && r.tagged == 1))
+		ties = ties || (f.depth == r.depth && r.count > 1)
+	}
+	return keep, ties
+}
+
+// namedTag puts name in the codec or json tag if it doesn't have one, for
+// fields whose Go name changes
+func namedTag(tag reflect.StructTag, name string) reflect.StructTag {
+	key := "codec"
+	value, ok := tag.Lookup(key)
+	if !ok {
+		key = "json"
+		value = tag.Get(key)
+	}
+	parts := strings.Split(value, ",")
+	if parts[0] != "" {
+		return tag
+	}
+	parts[0] = name
+	return setTag(tag, key, strings.Join(parts, ","))
+}
+
+// opaqueChecked records the opaque types checkOpaqueRedaction has looked at
+var opaqueChecked sync.Map
+
+// checkOpaqueRedaction logs an error if a type that marshals itself has redact
+// tags, as shaping can't reach inside it to apply them
+func checkOpaqueRedaction(t reflect.Type) {
+	if _, done := opaqueChecked.LoadOrStore(t, true); done {
+		return
+	}
+	if hasRedactTags(t, map[reflect.Type]bool{}) {
+		logging.Errorf("[shape] %s marshals itself so its redact tags are ignored, the fields are sent to every caller", t)
+	}
+}
+
+func hasRedactTags(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("redact") != "" || hasRedactTags(f.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldTag applies the naming policy and omitempty to the tag the codec
+// package reads, codec if the field has one and json otherwise
+func (s *shaper) fieldTag(f reflect.StructField) reflect.StructTag {
+	if s.opts.naming == NamingGo && !s.opts.omitEmpty {
+		return f.Tag
+	}
+	key := "codec"
+	value := f.Tag.Get(key)
+	if value == "" {
+		key = "json"
+		value = f.Tag.Get(key)
+	}
+	if value == "-" {
+		return f.Tag
+	}
+
+	parts := strings.Split(value, ",")
+	if parts[0] == "" {
+		parts[0] = fieldName(f.Name, s.opts.naming)
+	}
+	if s.opts.omitEmpty && !contains(parts[1:], "omitempty") {
+		parts = append(parts, "omitempty")
+	}
+	if joined := strings.Join(parts, ","); joined != value {
+		return setTag(f.Tag, key, joined)
+	}
+	return f.Tag
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the caller has one of the scopes in a redact tag
+func (s *shaper) allowed(tag string) bool {
+	for _, scope := range strings.Fields(strings.TrimSuffix(tag, ",mask")) {
+		if s.scopes[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex is FieldByIndex, returning an invalid value if an embedded
+// pointer on the way is nil instead of panicking
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
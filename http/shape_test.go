@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	basehttp "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type shadowBase struct {
+	ID    string
+	Name  string `json:"name"`
+	Email string
+}
+
+type shadowOther struct {
+	Email string
+	Name  string
+}
+
+type shadowPatient struct {
+	shadowBase
+	shadowOther
+	ID  int
+	NHS string `json:"nhs" redact:"pii"`
+}
+
+type stringerPatient struct {
+	Name string `json:"name"`
+	NHS  string `json:"nhs" redact:"pii,mask"`
+}
+
+func (p stringerPatient) String() string {
+	return p.Name + " " + p.NHS
+}
+
+func respondShaped(accept string, scopes []string, response interface{}) string {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", accept)
+	r = r.WithContext(WithScopes(context.Background(), scopes...))
+	rec := httptest.NewRecorder()
+	Respond(rec, r, basehttp.StatusOK, response)
+	return rec.Body.String()
+}
+
+func TestShapeEmbeddedFields(t *testing.T) {
+	patient := shadowPatient{
+		shadowBase:  shadowBase{ID: "base", Name: "tagged", Email: "base@example.com"},
+		shadowOther: shadowOther{Email: "other@example.com", Name: "untagged"},
+		ID:          7,
+		NHS:         "943",
+	}
+	// The outer ID hides the embedded one and neither Email is sent, as
+	// encoding/json does. name and Name are different names.
+	tests := []struct {
+		name   string
+		accept string
+		scopes []string
+		want   string
+	}{
+		{name: "json redacted", accept: "application/json", want: `{"ID":7,"Name":"untagged","name":"tagged"}`},
+		{name: "json scoped", accept: "application/json", scopes: []string{"pii"}, want: `{"ID":7,"Name":"untagged","name":"tagged","nhs":"943"}`},
+		{name: "xml", accept: "application/xml", scopes: []string{"pii"}, want: `<shadowPatient><name>tagged</name><Name>untagged</Name><ID>7</ID><nhs>943</nhs></shadowPatient>`},
+	}
+	SetXMLOptions(XMLOptions{OmitDeclaration: true})
+	defer SetXMLOptions(XMLOptions{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := respondShaped(test.accept, test.scopes, patient); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShapeKeepsMethodsForText(t *testing.T) {
+	patient := stringerPatient{Name: "Ada", NHS: "943"}
+	if got, want := respondShaped("text/plain", nil, patient), "Ada "+RedactedValue; got != want {
+		t.Errorf("text/plain = %q, want %q", got, want)
+	}
+	if got, want := respondShaped("text/plain", []string{"pii"}, patient), "Ada 943"; got != want {
+		t.Errorf("text/plain with scope = %q, want %q", got, want)
+	}
+	if got, want := respondShaped("application/json", nil, patient), `{"name":"Ada","nhs":"`+RedactedValue+`"}`; got != want {
+		t.Errorf("json = %s, want %s", got, want)
+	}
+}
+
+func TestDominantFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []namedField
+		want   []bool
+	}{
+		{"unique", []namedField{{"a", 1, false}, {"b", 2, false}}, []bool{true, true}},
+		{"shallowest wins", []namedField{{"a", 2, true}, {"a", 1, false}}, []bool{false, true}},
+		{"tie dropped", []namedField{{"a", 2, false}, {"a", 2, false}}, []bool{false, false}},
+		{"tagged wins tie", []namedField{{"a", 2, false}, {"a", 2, true}}, []bool{false, true}},
+		{"tagged tie dropped", []namedField{{"a", 2, true}, {"a", 2, true}}, []bool{false, false}},
+		{"deeper tie ignored", []namedField{{"a", 3, false}, {"a", 3, false}, {"a", 2, false}}, []bool{false, false, true}},
+	}
+	for _, test := range tests {
+		got, _ := dominantFields(test.fields)
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
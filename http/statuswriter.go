@@ -31,10 +31,34 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Flush passes through to the underlying writer so streaming responses work
+// behind the middleware that wraps it
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = 200
+	}
+	if f, ok := w.ResponseWriter.(basehttp.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, as net/http's ResponseController expects
+func (w *statusWriter) Unwrap() basehttp.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func GetResponseWriter(w interface{}) basehttp.ResponseWriter {
-	if out, ok := w.(*statusWriter); ok {
-		return out.ResponseWriter
+	out, ok := w.(*statusWriter)
+	if !ok {
+		return nil
 	}
 
-	return nil
+	// more than one middleware wraps the writer, so unwrap all of them
+	for {
+		inner, ok := out.ResponseWriter.(*statusWriter)
+		if !ok {
+			return out.ResponseWriter
+		}
+		out = inner
+	}
 }
@@ -0,0 +1,385 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	nh "net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DocHQ/helpers/requestid"
+)
+
+// Content types RespondStream can produce, in addition to application/json
+// (a JSON array) and application/cbor (an indefinite length CBOR array)
+const (
+	ContentTypeNDJSON      = "application/x-ndjson"
+	ContentTypeJSONSeq     = "application/json-seq"
+	ContentTypeCBORSeq     = "application/cbor-seq"
+	ContentTypeEventStream = "text/event-stream"
+)
+
+// StreamFunc returns the items of a stream one at a time, ok is false once
+// there are no more. It should give up when ctx is done, which happens when
+// the client goes away.
+type StreamFunc func(ctx context.Context) (item interface{}, ok bool, err error)
+
+// StreamChannel adapts a channel to a StreamFunc, the stream ends when the
+// channel is closed. errs may be nil, otherwise the first error received on it
+// ends the stream with that error.
+func StreamChannel(items <-chan interface{}, errs <-chan error) StreamFunc {
+	return func(ctx context.Context) (interface{}, bool, error) {
+		select {
+		case item, ok := <-items:
+			return item, ok, nil
+		case err := <-errs:
+			return nil, false, err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// ServerSentEvent lets a stream item control the event fields when the
+// response is text/event-stream, for other formats only Data is sent
+type ServerSentEvent struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// RespondStream writes the items from next as they are produced, so large
+// lists never need to be held in memory. The format follows the Accept header:
+//
+//	application/json        a JSON array (the default)
+//	application/x-ndjson    one JSON document per line
+//	application/json-seq    RFC 7464 JSON text sequence
+//	application/cbor        an indefinite length CBOR array
+//	application/cbor-seq    RFC 8742 CBOR sequence
+//	text/event-stream       server-sent events, JSON in the data field
+//
+// Output is flushed as it goes. The stream stops if the client disconnects,
+// the server's WriteTimeout doesn't apply to it.
+// If next fails before the first item, a 500 is sent with RespondError and the
+// error is logged, not sent, as it may be internal.
+// After that the status has been sent, so for the sequence formats a final
+// {"error": ResponseError} record for a 500 is written (an "error" event for
+// text/event-stream), while the array formats have no way to express it and
+// the connection is aborted instead, as Respond does.
+func RespondStream(w nh.ResponseWriter, r *nh.Request, statusCode int, next StreamFunc) {
+	ctx := r.Context()
+	contentType := decideStreamAccept(r.Header.Get("Accept"))
+
+	// Get the first item before committing to a status
+	item, ok, err := next(ctx)
+	if err != nil {
+		log.Println("[RespondStream] Stream Error:", contentType, err)
+		RespondError(w, r, nh.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	if contentType == ContentTypeEventStream {
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no") // stop nginx holding events back
+	}
+	w.WriteHeader(statusCode) // commit point. contentType and statusCode are now on the wire
+	clearWriteDeadline(w)
+
+	// Indenting would break the sequence formats, which are a record a line
+	opts := DefaultEncodeOptions()
+	opts.Indent = 0
+	s := newStreamEncoder(w, contentType, opts)
+	shaper := newShaper(r, contentType, opts)
+	if err = s.start(); err != nil {
+		ok = false
+	}
+	for ok {
+		if event, isEvent := item.(ServerSentEvent); isEvent {
+			event.Data = shaper.shape(event.Data)
+			item = event
+		} else {
+			item = shaper.shape(item)
+		}
+		if err = s.item(item); err != nil {
+			break
+		}
+		if ctx.Err() != nil {
+			// The client has gone, nobody is listening
+			return
+		}
+		item, ok, err = next(ctx)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err == nil {
+		err = s.end()
+	}
+	if err != nil {
+		log.Println("[RespondStream] Stream Error:", contentType, err)
+		if !s.writeError(r, err) {
+			panic(abortResponse(fmt.Sprintf("[RespondStream] failed to send response. Content-Type: %s. Error: %s", contentType, err.Error())))
+		}
+	}
+	s.flush(true)
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+// streamFlushInterval limits how often the array and sequence formats flush,
+// events are always flushed straight away
+const streamFlushInterval = 100 * time.Millisecond
+
+// decideStreamAccept picks the most preferred type in the Accept header that
+// can be streamed, defaulting to a JSON array
+func decideStreamAccept(accept string) string {
+	for _, mediaType := range acceptedTypes(accept) {
+		switch mediaType {
+		case "application/json", ContentTypeNDJSON, ContentTypeJSONSeq,
+			"application/cbor", ContentTypeCBORSeq, ContentTypeEventStream:
+			return mediaType
+		case "*/*", "application/*":
+			return "application/json"
+		}
+	}
+	return "application/json"
+}
+
+// acceptedTypes parses an Accept header into its media types, most preferred
+// first. Types with the same q value keep their order, except that wildcards
+// come after the types they match. Types with q=0 are left out.
+func acceptedTypes(header string) []string {
+	type accepted struct {
+		mediaType   string
+		q           float64
+		specificity int
+	}
+	var types []accepted
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		specificity := 2
+		if mediaType == "*/*" {
+			specificity = 0
+		} else if strings.HasSuffix(mediaType, "/*") {
+			specificity = 1
+		}
+		types = append(types, accepted{mediaType: mediaType, q: q, specificity: specificity})
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		if types[i].q != types[j].q {
+			return types[i].q > types[j].q
+		}
+		return types[i].specificity > types[j].specificity
+	})
+
+	mediaTypes := make([]string, len(types))
+	for i, t := range types {
+		mediaTypes[i] = t.mediaType
+	}
+	return mediaTypes
+}
+
+type streamEncoder struct {
+	w           nh.ResponseWriter
+	contentType string
+	json        *codecPool
+	cbor        *codecPool
+	buf         bytes.Buffer
+	count       int
+	lastFlush   time.Time
+}
+
+func newStreamEncoder(w nh.ResponseWriter, contentType string, opts EncodeOptions) *streamEncoder {
+	return &streamEncoder{
+		w:           w,
+		contentType: contentType,
+		json:        jsonCodec(opts),
+		cbor:        cborCodec,
+		lastFlush:   time.Now(),
+	}
+}
+
+func (s *streamEncoder) start() error {
+	switch s.contentType {
+	case "application/json":
+		_, err := io.WriteString(s.w, "[")
+		return err
+	case "application/cbor":
+		_, err := s.w.Write([]byte{0x9f}) // start of indefinite length array
+		return err
+	}
+	return nil
+}
+
+func (s *streamEncoder) item(item interface{}) error {
+	event, isEvent := item.(ServerSentEvent)
+	if isEvent && s.contentType != ContentTypeEventStream {
+		item = event.Data
+	}
+
+	// Encode into a buffer so a failure doesn't leave half an item on the wire
+	s.buf.Reset()
+	var err error
+	switch s.contentType {
+	case "application/json":
+		if s.count > 0 {
+			s.buf.WriteByte(',')
+		}
+		err = s.json.encode(&s.buf, item)
+	case ContentTypeNDJSON:
+		err = s.json.encode(&s.buf, item)
+		s.buf.WriteByte('\n')
+	case ContentTypeJSONSeq:
+		s.buf.WriteByte(0x1e) // record separator
+		err = s.json.encode(&s.buf, item)
+		s.buf.WriteByte('\n')
+	case "application/cbor", ContentTypeCBORSeq:
+		err = s.cbor.encode(&s.buf, item)
+	case ContentTypeEventStream:
+		if !isEvent {
+			event = ServerSentEvent{Data: item}
+		}
+		err = s.event(event)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.w.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	s.count++
+	s.flush(s.contentType == ContentTypeEventStream)
+	return nil
+}
+
+func (s *streamEncoder) event(event ServerSentEvent) error {
+	if event.ID != "" {
+		fmt.Fprintf(&s.buf, "id: %s\n", oneLine(event.ID))
+	}
+	if event.Event != "" {
+		fmt.Fprintf(&s.buf, "event: %s\n", oneLine(event.Event))
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&s.buf, "retry: %s\n", strconv.FormatInt(event.Retry.Milliseconds(), 10))
+	}
+
+	data, ok := event.Data.(string)
+	if !ok {
+		buf := getBuffer()
+		err := s.json.encode(buf, event.Data)
+		data = buf.String()
+		putBuffer(buf)
+		if err != nil {
+			return err
+		}
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&s.buf, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	s.buf.WriteByte('\n')
+	return nil
+}
+
+func (s *streamEncoder) end() error {
+	switch s.contentType {
+	case "application/json":
+		_, err := io.WriteString(s.w, "]")
+		return err
+	case "application/cbor":
+		_, err := s.w.Write([]byte{0xff}) // break
+		return err
+	}
+	return nil
+}
+
+// writeError reports an error after the status has been sent, returning
+// false if the format has no way to do that
+func (s *streamEncoder) writeError(r *nh.Request, err error) bool {
+	response := struct {
+		Error ResponseError `json:"error"`
+	}{ResponseError{
+		StatusCode: nh.StatusInternalServerError,
+		Code:       statusErrorCode(nh.StatusInternalServerError),
+		Message:    nh.StatusText(nh.StatusInternalServerError),
+		RequestID:  requestid.FromContext(r.Context()),
+	}}
+
+	s.buf.Reset()
+	switch s.contentType {
+	case ContentTypeNDJSON:
+		err = s.json.encode(&s.buf, response)
+		s.buf.WriteByte('\n')
+	case ContentTypeJSONSeq:
+		s.buf.WriteByte(0x1e)
+		err = s.json.encode(&s.buf, response)
+		s.buf.WriteByte('\n')
+	case ContentTypeCBORSeq:
+		err = s.cbor.encode(&s.buf, response)
+	case ContentTypeEventStream:
+		err = s.event(ServerSentEvent{Event: "error", Data: response.Error})
+	default:
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	_, err = s.w.Write(s.buf.Bytes())
+	return err == nil
+}
+
+func (s *streamEncoder) flush(force bool) {
+	if !force && time.Since(s.lastFlush) < streamFlushInterval {
+		return
+	}
+	if f, ok := s.w.(nh.Flusher); ok {
+		f.Flush()
+	}
+	s.lastFlush = time.Now()
+}
+
+// clearWriteDeadline lifts the server's WriteTimeout for a stream, which
+// would otherwise cut it off part way. This is what ResponseController does
+// from Go 1.20, on older versions the writer has no SetWriteDeadline and the
+// timeout stays.
+func clearWriteDeadline(w nh.ResponseWriter) {
+	for {
+		switch t := w.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			t.SetWriteDeadline(time.Time{})
+			return
+		case interface{ Unwrap() nh.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+// oneLine stops a value breaking out of its server-sent event field
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"context"
+	"errors"
+	basehttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecideStreamAccept(t *testing.T) {
+	tests := map[string]string{
+		"":                                    "application/json",
+		"application/x-ndjson":                ContentTypeNDJSON,
+		"text/event-stream, application/json": ContentTypeEventStream,
+		"application/x-ndjson;q=0":            "application/json",
+		"application/x-ndjson;q=0, application/cbor-seq": ContentTypeCBORSeq,
+		"application/json;q=0.5, application/json-seq":   ContentTypeJSONSeq,
+		"*/*, application/x-ndjson;q=0.5":                "application/json",
+		"text/html, */*;q=0.1, application/cbor;q=0.5":   "application/cbor",
+		"application/x-ndjson;q=nope":                    "application/json",
+	}
+	for accept, want := range tests {
+		if got := decideStreamAccept(accept); got != want {
+			t.Errorf("decideStreamAccept(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func TestRespondStreamHidesErrors(t *testing.T) {
+	secret := errors.New("dial tcp 10.0.0.7:5432: password authentication failed")
+	stream := func(items int) StreamFunc {
+		return func(ctx context.Context) (interface{}, bool, error) {
+			if items == 0 {
+				return nil, false, secret
+			}
+			items--
+			return map[string]int{"n": items}, true, nil
+		}
+	}
+
+	tests := []struct {
+		name       string
+		items      int
+		wantStatus int
+		want       string
+	}{
+		{name: "before the first item", items: 0, wantStatus: 500, want: `"message":"Internal Server Error"`},
+		{name: "part way", items: 2, wantStatus: 200, want: `"message":"Internal Server Error","status_code":500}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept", ContentTypeNDJSON)
+			rec := httptest.NewRecorder()
+			RespondStream(rec, r, basehttp.StatusOK, stream(test.items))
+			if rec.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, test.wantStatus)
+			}
+			if strings.Contains(rec.Body.String(), "password") {
+				t.Errorf("the error was sent: %s", rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), test.want) {
+				t.Errorf("body = %s, want %s", rec.Body, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package http
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	nh "net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/DocHQ/helpers/internal/env"
+
+	"github.com/gorilla/mux"
+)
+
+// TrustedHTML marks a string as safe HTML that templates write as it is.
+// Everything else in a response is escaped, so only use it for markup the
+// service produced itself, never for anything a user supplied.
+type TrustedHTML = htmltemplate.HTML
+
+// HTMLTemplateOptions configures the templates used for text/html and
+// application/html responses, see SetHTMLTemplates
+type HTMLTemplateOptions struct {
+	// FS holds the templates, e.g. an embed.FS. The current directory if nil.
+	FS fs.FS
+
+	// Shared are glob patterns for layouts and partials, available to every page
+	Shared []string
+
+	// Pages are glob patterns for the pages. Each is parsed on its own with
+	// the shared templates, so pages can each define the blocks a layout
+	// uses, and is named by its file name, e.g. "patient.html". File names
+	// must be unique, pages in different directories can't share one.
+	Pages []string
+
+	// Funcs are added to the template functions, which already include
+	// fieldExists, trustedHTML and nl2br
+	Funcs htmltemplate.FuncMap
+
+	// TextHTML and ApplicationHTML name the pages used for full pages and
+	// fragments when the handler doesn't choose one. If empty, that type is
+	// only sent by RespondTemplate.
+	TextHTML        string
+	ApplicationHTML string
+
+	// Reload parses the templates again for every response so changes show
+	// up without a restart, for development. Also enabled by setting the
+	// HTML_TEMPLATE_RELOAD environment variable to true before the templates
+	// are set.
+	Reload bool
+}
+
+// SetHTMLTemplates parses the templates used for HTML responses, replacing
+// any set before. Templates use html/template, so the response is escaped
+// unless it is TrustedHTML.
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	err := http.SetHTMLTemplates(http.HTMLTemplateOptions{
+//		FS:       templates,
+//		Shared:   []string{"templates/layouts/*.html", "templates/partials/*.html"},
+//		Pages:    []string{"templates/pages/*.html"},
+//		TextHTML: "default.html",
+//	})
+//
+// A page uses a layout by calling it after defining its blocks:
+//
+//	{{define "content"}}<h1>{{.Name}}</h1>{{end}}
+//	{{template "layout.html" .}}
+func SetHTMLTemplates(opts HTMLTemplateOptions) error {
+	opts.Reload = opts.Reload || env.Bool("HTML_TEMPLATE_RELOAD", false)
+	engine := &htmlEngine{opts: opts}
+	pages, err := engine.load()
+	if err != nil {
+		return err
+	}
+	for _, page := range []string{opts.TextHTML, opts.ApplicationHTML} {
+		if _, ok := pages[page]; page != "" && !ok {
+			return fmt.Errorf("[html] no page named %q", page)
+		}
+	}
+	htmlTemplates.Lock()
+	htmlTemplates.engine = engine
+	htmlTemplates.Unlock()
+	return nil
+}
+
+// SetHTMLTemplatePaths allows the application to specify the paths to HTML tempate
+// files that will be used for responses with HTML content-types.
+// If a template path is empty, it will not be used and the default content-type
+// (application/json) will be used instead.
+// Template paths are empty by default.
+// The files are parsed straight away with html/template, see SetHTMLTemplates,
+// and the templates are left as they were if that fails. Pages are named by
+// file name, so the two files can't have the same one.
+func SetHTMLTemplatePaths(applicationHTML, textHTML string) error {
+	engine := &htmlEngine{opts: HTMLTemplateOptions{Reload: env.Bool("HTML_TEMPLATE_RELOAD", false)}}
+	for _, file := range []string{applicationHTML, textHTML} {
+		if file != "" && (len(engine.files) == 0 || engine.files[0] != file) {
+			engine.files = append(engine.files, file)
+		}
+	}
+	if applicationHTML != "" {
+		engine.opts.ApplicationHTML = filepath.Base(applicationHTML)
+	}
+	if textHTML != "" {
+		engine.opts.TextHTML = filepath.Base(textHTML)
+	}
+	if _, err := engine.load(); err != nil {
+		return err
+	}
+	htmlTemplates.Lock()
+	htmlTemplates.engine = engine
+	htmlTemplates.Unlock()
+	return nil
+}
+
+// RegisterHTMLTemplate makes Respond render values of the same type as value
+// with page when the client accepts HTML, e.g.
+//
+//	http.RegisterHTMLTemplate(Patient{}, "patient.html")
+//	http.RegisterHTMLTemplate([]Patient{}, "patients.html")
+//
+// Pointers are treated as the type they point to. If the templates have no
+// such page the response is sent as if none was registered.
+func RegisterHTMLTemplate(value interface{}, page string) {
+	t := reflect.TypeOf(value)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	htmlTemplates.Lock()
+	defer htmlTemplates.Unlock()
+	htmlTemplates.byType[t] = page
+}
+
+// RegisterRouteHTMLTemplate makes Respond render responses to the route with
+// this name (see mux.Route.Name) with page when the client accepts HTML.
+// Routes take precedence over types.
+func RegisterRouteHTMLTemplate(route, page string) {
+	htmlTemplates.Lock()
+	defer htmlTemplates.Unlock()
+	htmlTemplates.byRoute[route] = page
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+var htmlTemplates = struct {
+	sync.RWMutex
+	engine     *htmlEngine
+	byType     map[reflect.Type]string
+	byRoute    map[string]string
+	errorPages map[int]htmlErrorPages
+}{
+	byType:     map[reflect.Type]string{},
+	byRoute:    map[string]string{},
+	errorPages: map[int]htmlErrorPages{},
+}
+
+// htmlTemplateFor picks the page for a response: the one the handler chose,
+// then the route's, then the type's. "" means the default for the content type.
+// Registered pages that aren't in the templates are skipped, so the response
+// falls back to the default page or JSON rather than failing.
+func htmlTemplateFor(r *nh.Request, page string, response interface{}) string {
+	if page != "" {
+		return page
+	}
+
+	var candidates []string
+	htmlTemplates.RLock()
+	engine := htmlTemplates.engine
+	if len(htmlTemplates.byRoute) > 0 {
+		if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+			if page, ok := htmlTemplates.byRoute[route.GetName()]; ok {
+				candidates = append(candidates, page)
+			}
+		}
+	}
+	if len(htmlTemplates.byType) > 0 {
+		t := reflect.TypeOf(response)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if page, ok := htmlTemplates.byType[t]; ok {
+			candidates = append(candidates, page)
+		}
+	}
+	htmlTemplates.RUnlock()
+
+	for _, page := range candidates {
+		if engine.has(page) {
+			return page
+		}
+	}
+	return ""
+}
+
+// negotiateResponse decides the content type of a success response and the
+// page to render it with if that is HTML. Having a page of its own makes HTML
+// available even when there is no default template.
+func negotiateResponse(r *nh.Request, page string, response interface{}) (string, string) {
+	page = htmlTemplateFor(r, page, response)
+	if page != "" {
+		if html := acceptHTML(r.Header); html != "" {
+			return html, page
+		}
+	}
+	return decideAccept(r.Header), page
+}
+
+// templateFuncs are available to every template
+var templateFuncs = htmltemplate.FuncMap{
+	"fieldExists": fieldExists,
+	"trustedHTML": func(s string) TrustedHTML { return TrustedHTML(s) },
+	"nl2br":       nl2br,
+}
+
+type htmlEngine struct {
+	opts HTMLTemplateOptions
+
+	// files are pages read from disk by path, for SetHTMLTemplatePaths
+	files []string
+
+	once  sync.Once
+	pages map[string]*htmltemplate.Template
+	err   error
+}
+
+func currentHTMLEngine() *htmlEngine {
+	htmlTemplates.RLock()
+	defer htmlTemplates.RUnlock()
+	return htmlTemplates.engine
+}
+
+// defaultHTMLTemplate is the page for an HTML content type when the handler
+// doesn't choose, "" if that type isn't available
+func defaultHTMLTemplate(contentType string) string {
+	engine := currentHTMLEngine()
+	if engine == nil {
+		return ""
+	}
+	switch contentType {
+	case "text/html":
+		return engine.opts.TextHTML
+	case "application/html":
+		return engine.opts.ApplicationHTML
+	}
+	return ""
+}
+
+// renderHTML writes the named page with response as its data
+func renderHTML(w io.Writer, name string, response interface{}) error {
+	engine := currentHTMLEngine()
+	if engine == nil {
+		return fmt.Errorf("[html] no templates have been set")
+	}
+	pages, err := engine.load()
+	if err != nil {
+		return err
+	}
+	t, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("[html] no template named %q", name)
+	}
+
+	// Render to a buffer so a failure part way doesn't send half a page
+	out := getBuffer()
+	defer putBuffer(out)
+	if err := t.ExecuteTemplate(out, name, response); err != nil {
+		return err
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// has reports whether there is a page called name
+func (e *htmlEngine) has(name string) bool {
+	if e == nil {
+		return false
+	}
+	pages, err := e.load()
+	if err != nil {
+		return false
+	}
+	_, ok := pages[name]
+	return ok
+}
+
+// load parses the templates once, or every time when reloading
+func (e *htmlEngine) load() (map[string]*htmltemplate.Template, error) {
+	if e.opts.Reload {
+		return e.parse()
+	}
+	e.once.Do(func() {
+		e.pages, e.err = e.parse()
+	})
+	return e.pages, e.err
+}
+
+func (e *htmlEngine) parse() (map[string]*htmltemplate.Template, error) {
+	fsys := e.opts.FS
+	if fsys == nil {
+		fsys = os.DirFS(".")
+	}
+
+	shared := htmltemplate.New("").Funcs(templateFuncs).Funcs(e.opts.Funcs)
+	for _, pattern := range e.opts.Shared {
+		if _, err := shared.ParseFS(fsys, pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	pages := map[string]*htmltemplate.Template{}
+	add := func(name string, text []byte) error {
+		if _, ok := pages[name]; ok {
+			return fmt.Errorf("[html] more than one page is named %q", name)
+		}
+		t, err := shared.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.New(name).Parse(string(text)); err != nil {
+			return err
+		}
+		pages[name] = t
+		return nil
+	}
+
+	for _, pattern := range e.opts.Pages {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("[html] pattern matches no files: %s", pattern)
+		}
+		for _, match := range matches {
+			text, err := fs.ReadFile(fsys, match)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(path.Base(match), text); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, file := range e.files {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(filepath.Base(file), text); err != nil {
+			return nil, err
+		}
+	}
+
+	return pages, nil
+}
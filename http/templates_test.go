@@ -0,0 +1,107 @@
+package http
+
+import (
+	basehttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func resetHTMLTemplates() {
+	htmlTemplates.Lock()
+	htmlTemplates.engine = nil
+	htmlTemplates.byType = map[reflect.Type]string{}
+	htmlTemplates.byRoute = map[string]string{}
+	htmlTemplates.errorPages = map[int]htmlErrorPages{}
+	htmlTemplates.Unlock()
+}
+
+func respondHTML(response interface{}) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	Respond(rec, r, basehttp.StatusOK, response)
+	return rec
+}
+
+func TestHTMLTemplateReload(t *testing.T) {
+	defer resetHTMLTemplates()
+	fsys := fstest.MapFS{"page.html": {Data: []byte(`<p>v1 {{.}}</p>`)}}
+
+	tests := []struct {
+		name   string
+		reload bool
+		env    string
+		want   string
+	}{
+		{name: "parsed once", want: "<p>v1 x</p>"},
+		{name: "reload option", reload: true, want: "<p>v2 x</p>"},
+		{name: "reload variable", env: "true", want: "<p>v2 x</p>"},
+		{name: "invalid variable", env: "sometimes", want: "<p>v1 x</p>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys["page.html"].Data = []byte(`<p>v1 {{.}}</p>`)
+			os.Setenv("HTML_TEMPLATE_RELOAD", test.env)
+			err := SetHTMLTemplates(HTMLTemplateOptions{FS: fsys, Pages: []string{"*.html"}, TextHTML: "page.html", Reload: test.reload})
+			// The variable is read when the templates are set
+			os.Unsetenv("HTML_TEMPLATE_RELOAD")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := respondHTML("x").Body.String(); got != "<p>v1 x</p>" {
+				t.Fatalf("first response = %q", got)
+			}
+			fsys["page.html"].Data = []byte(`<p>v2 {{.}}</p>`)
+			if got := respondHTML("x").Body.String(); got != test.want {
+				t.Errorf("after the change = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTMLTemplateMissingPage(t *testing.T) {
+	defer resetHTMLTemplates()
+	fsys := fstest.MapFS{
+		"pages/page.html":  {Data: []byte(`<p>{{.}}</p>`)},
+		"other/page.html":  {Data: []byte(`<p>other</p>`)},
+		"pages/shout.html": {Data: []byte(`<h1>{{.}}</h1>`)},
+	}
+
+	if err := SetHTMLTemplates(HTMLTemplateOptions{FS: fsys, Pages: []string{"pages/*.html"}, TextHTML: "page.html"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range map[string]HTMLTemplateOptions{
+		"default page":    {FS: fsys, Pages: []string{"pages/*.html"}, TextHTML: "missing.html"},
+		"fragment page":   {FS: fsys, Pages: []string{"pages/*.html"}, ApplicationHTML: "missing.html"},
+		"no files":        {FS: fsys, Pages: []string{"none/*.html"}},
+		"same file name":  {FS: fsys, Pages: []string{"pages/*.html", "other/*.html"}},
+		"template syntax": {FS: fstest.MapFS{"bad.html": {Data: []byte(`{{.`)}}, Pages: []string{"*.html"}},
+	} {
+		if err := SetHTMLTemplates(opts); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if err := SetHTMLTemplatePaths("", filepath.Join(t.TempDir(), "missing.html")); err == nil {
+		t.Error("SetHTMLTemplatePaths with a missing file: no error")
+	}
+
+	// The templates set first are still used
+	if got := respondHTML("x").Body.String(); got != "<p>x</p>" {
+		t.Errorf("after failed setups = %q, want <p>x</p>", got)
+	}
+
+	// A registered page that doesn't exist falls back to the default page
+	type shouted string
+	RegisterHTMLTemplate(shouted(""), "missing.html")
+	if got := respondHTML(shouted("x")).Body.String(); got != "<p>x</p>" {
+		t.Errorf("registered missing page = %q, want the default page", got)
+	}
+	RegisterHTMLTemplate(shouted(""), "shout.html")
+	if got := respondHTML(shouted("x")).Body.String(); got != "<h1>x</h1>" {
+		t.Errorf("registered page = %q, want <h1>x</h1>", got)
+	}
+}
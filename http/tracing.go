@@ -0,0 +1,47 @@
+package http
+
+import (
+	basehttp "net/http"
+
+	"github.com/DocHQ/helpers/tracing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing is middleware that creates a server span for each request, named by
+// the mux route template so that /users/1 and /users/2 are grouped together.
+// Any W3C traceparent sent by the caller becomes the parent of the span.
+// New installs this on every Router, the spans go nowhere until tracing.Init
+// is called.
+func Tracing(next basehttp.Handler) basehttp.Handler {
+	return basehttp.HandlerFunc(func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		name := "HTTP " + r.Method
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				name, route = tmpl, tmpl
+			}
+		}
+
+		ctx, span := tracing.Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, r)...),
+		)
+		defer span.End()
+
+		sw := statusWriter{ResponseWriter: w}
+		next.ServeHTTP(&sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = basehttp.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+	})
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	basehttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DocHQ/helpers/tracing/tracingtest"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracedRouter(t *testing.T) (*Router, *tracingtest.Recorder) {
+	t.Helper()
+	provider := tracingtest.Init()
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	sink := accessLogSink
+	SetAccessLogSink(nil)
+	t.Cleanup(func() { SetAccessLogSink(sink) })
+
+	router := New()
+	router.HandleFunc("/patients/{id}", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			RespondError(w, r, basehttp.StatusInternalServerError, "broken")
+			return
+		}
+		Respond(w, r, basehttp.StatusOK, map[string]string{"id": "1"})
+	})
+	return router, provider
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingNamesSpanByRoute(t *testing.T) {
+	router, provider := newTracedRouter(t)
+
+	for _, path := range []string{"/patients/1", "/patients/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	spans := provider.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for _, span := range spans {
+		if span.Name != "/patients/{id}" {
+			t.Errorf("span name = %q, want the route template", span.Name)
+		}
+		if span.SpanKind != trace.SpanKindServer {
+			t.Errorf("span kind = %v, want server", span.SpanKind)
+		}
+		if v, _ := attributeValue(span.Attributes, semconv.HTTPRouteKey); v.AsString() != "/patients/{id}" {
+			t.Errorf("http.route = %q", v.AsString())
+		}
+		if v, _ := attributeValue(span.Attributes, semconv.HTTPStatusCodeKey); v.AsInt64() != 200 {
+			t.Errorf("http.status_code = %d, want 200", v.AsInt64())
+		}
+		if span.Status.Code == codes.Error {
+			t.Errorf("span status = %v, want unset", span.Status.Code)
+		}
+	}
+}
+
+func TestTracingContinuesTraceparent(t *testing.T) {
+	router, provider := newTracedRouter(t)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	req := httptest.NewRequest("GET", "/patients/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := provider.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if got := span.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace id = %s, want %s", got, traceID)
+	}
+	if got := span.Parent.SpanID().String(); got != parentID {
+		t.Errorf("parent span id = %s, want %s", got, parentID)
+	}
+	if !span.Parent.IsRemote() {
+		t.Error("parent should be remote")
+	}
+}
+
+func TestTracingMarksServerErrors(t *testing.T) {
+	router, provider := newTracedRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/patients/1?fail=1", nil))
+	if rec.Code != basehttp.StatusInternalServerError {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	spans := provider.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if v, _ := attributeValue(spans[0].Attributes, semconv.HTTPStatusCodeKey); v.AsInt64() != 500 {
+		t.Errorf("http.status_code = %d, want 500", v.AsInt64())
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("span status = %v, want error", spans[0].Status.Code)
+	}
+}
@@ -0,0 +1,502 @@
+package http
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// XMLOptions configures application/xml responses, see SetXMLOptions
+type XMLOptions struct {
+	// Root names the root element of responses that don't name their own
+	// with an XMLName field. If empty it is the name of the response's type
+	// (from before the fields parameter and redact tags are applied), or
+	// "response" for slices, maps and types without a name.
+	Root string
+
+	// Namespace is the xmlns of the root element
+	Namespace string
+
+	// OmitDeclaration leaves out the <?xml version="1.0" encoding="UTF-8"?>
+	// at the start of every document
+	OmitDeclaration bool
+}
+
+// SetXMLOptions sets the options for application/xml responses.
+//
+// Responses are encoded like JSON: element names come from xml tags, then
+// json tags, then the field names, and omitempty in either tag is honoured.
+// Maps have an element per key, in key order, slices repeat their element
+// (and are wrapped in item elements at the root), []byte is base64 and nil
+// values are left out. The xml tag options attr, chardata, innerxml, comment,
+// omitempty and parent>child names work as they do in encoding/xml, and
+// types with a MarshalXML or MarshalText method are encoded with it.
+func SetXMLOptions(opts XMLOptions) {
+	xmlOptions.Lock()
+	defer xmlOptions.Unlock()
+	xmlOptions.opts = opts
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+
+var xmlOptions struct {
+	sync.RWMutex
+	opts XMLOptions
+}
+
+func currentXMLOptions() XMLOptions {
+	xmlOptions.RLock()
+	defer xmlOptions.RUnlock()
+	return xmlOptions.opts
+}
+
+// encodeXML streams response to w as an XML document. Like JSON and CBOR a
+// failure part way through aborts the response, see Respond.
+func encodeXML(w io.Writer, response interface{}, opts EncodeOptions) error {
+	xopts := currentXMLOptions()
+	e := xmlEncoder{w: w, enc: xml.NewEncoder(w)}
+	e.enc.Indent("", xmlIndent(opts))
+
+	if !xopts.OmitDeclaration {
+		declaration := xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8"`)}
+		if err := e.enc.EncodeToken(declaration); err != nil {
+			return err
+		}
+	}
+
+	v := reflect.ValueOf(response)
+	start := xml.StartElement{Name: xml.Name{Space: xopts.Namespace, Local: xopts.Root}}
+	if start.Name.Local == "" {
+		start.Name.Local = opts.root
+	}
+	if start.Name.Local == "" {
+		start.Name.Local = "response"
+		if v.IsValid() {
+			if name := xmlTypeName(v.Type()); name != "" {
+				start.Name.Local = name
+			}
+		}
+	}
+	if err := e.root(start, v); err != nil {
+		return err
+	}
+	return e.enc.Flush()
+}
+
+type xmlEncoder struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+var xmlMarshalerType = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+
+// root writes the document element, which is always there even if the
+// response is nil or a slice
+func (e xmlEncoder) root(start xml.StartElement, v reflect.Value) error {
+	v = indirect(v)
+	switch {
+	case !v.IsValid():
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 && !isXMLMarshaler(v.Type()):
+		if err := e.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.element(xml.StartElement{Name: xml.Name{Local: "item"}}, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return e.enc.EncodeToken(start.End())
+	default:
+		return e.element(start, v, false)
+	}
+	if err := e.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	return e.enc.EncodeToken(start.End())
+}
+
+func (e xmlEncoder) element(start xml.StartElement, v reflect.Value, omitEmpty bool) error {
+	v = indirect(v)
+	if !v.IsValid() || (omitEmpty && v.IsZero()) {
+		return nil
+	}
+	if m, ok := xmlMarshaler(v); ok {
+		return e.enc.EncodeElement(m, start)
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		// Repeating elements
+		for i := 0; i < v.Len(); i++ {
+			if err := e.element(start, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		return e.mapElement(start, v)
+
+	case reflect.Struct:
+		return e.structElement(start, v)
+	}
+
+	text, err := xmlText(v)
+	if err != nil {
+		return err
+	}
+	if err := e.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := e.enc.EncodeToken(xml.CharData(text)); err != nil {
+		return err
+	}
+	return e.enc.EncodeToken(start.End())
+}
+
+// mapElement writes an element per key, or an entry element with a key
+// attribute for keys that aren't valid element names
+func (e xmlEncoder) mapElement(start xml.StartElement, v reflect.Value) error {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := xmlText(indirect(iter.Key()))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	if err := e.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := xml.StartElement{Name: xml.Name{Local: entry.key}}
+		if !validXMLName(entry.key) {
+			child = xml.StartElement{
+				Name: xml.Name{Local: "entry"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: entry.key}},
+			}
+		}
+		if err := e.element(child, entry.value, false); err != nil {
+			return err
+		}
+	}
+	return e.enc.EncodeToken(start.End())
+}
+
+func (e xmlEncoder) structElement(start xml.StartElement, v reflect.Value) error {
+	info := xmlStructInfoFor(v.Type())
+
+	// As in encoding/xml the XMLName tag, then its value, beats the field name
+	if info.name.Local != "" {
+		start.Name.Local = info.name.Local
+		if info.name.Space != "" {
+			start.Name.Space = info.name.Space
+		}
+	} else if info.nameIndex != nil {
+		if name, ok := fieldByIndex(v, info.nameIndex).Interface().(xml.Name); ok && name.Local != "" {
+			start.Name = name
+		}
+	}
+
+	var children []xmlField
+	for _, f := range info.fields {
+		if f.kind != xmlFieldAttr {
+			children = append(children, f)
+			continue
+		}
+		fv := indirect(fieldByIndex(v, f.index))
+		if !fv.IsValid() || (f.omitEmpty && fv.IsZero()) {
+			continue
+		}
+		text, err := xmlText(fv)
+		if err != nil {
+			return err
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: f.name, Value: text})
+	}
+
+	if err := e.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	var parents []string
+	for _, f := range children {
+		fv := indirect(fieldByIndex(v, f.index))
+		if !fv.IsValid() || (f.omitEmpty && fv.IsZero()) {
+			continue
+		}
+
+		// Close the parents this field doesn't share and open the ones it needs
+		common := 0
+		for common < len(parents) && common < len(f.parents) && parents[common] == f.parents[common] {
+			common++
+		}
+		for i := len(parents) - 1; i >= common; i-- {
+			if err := e.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: parents[i]}}); err != nil {
+				return err
+			}
+		}
+		for _, parent := range f.parents[common:] {
+			if err := e.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: parent}}); err != nil {
+				return err
+			}
+		}
+		parents = f.parents
+
+		var err error
+		switch f.kind {
+		case xmlFieldCharData:
+			var text string
+			if text, err = xmlText(fv); err == nil {
+				err = e.enc.EncodeToken(xml.CharData(text))
+			}
+		case xmlFieldComment:
+			var text string
+			if text, err = xmlText(fv); err == nil {
+				err = e.enc.EncodeToken(xml.Comment(text))
+			}
+		case xmlFieldInnerXML:
+			// Already markup
+			if err = e.enc.Flush(); err == nil {
+				if fv.Kind() == reflect.String {
+					_, err = io.WriteString(e.w, fv.String())
+				} else if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8 {
+					_, err = e.w.Write(fv.Bytes())
+				}
+			}
+		default:
+			err = e.element(xml.StartElement{Name: f.name}, fv, f.omitEmpty)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if err := e.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: parents[i]}}); err != nil {
+			return err
+		}
+	}
+	return e.enc.EncodeToken(start.End())
+}
+
+type xmlFieldKind int
+
+const (
+	xmlFieldElement xmlFieldKind = iota
+	xmlFieldAttr
+	xmlFieldCharData
+	xmlFieldInnerXML
+	xmlFieldComment
+)
+
+type xmlField struct {
+	index     []int
+	name      xml.Name
+	parents   []string
+	kind      xmlFieldKind
+	omitEmpty bool
+}
+
+type xmlStructInfo struct {
+	name      xml.Name // from the XMLName tag
+	nameIndex []int    // of the XMLName field, nil if none
+	fields    []xmlField
+}
+
+var xmlStructInfos sync.Map
+
+func xmlStructInfoFor(t reflect.Type) *xmlStructInfo {
+	if info, ok := xmlStructInfos.Load(t); ok {
+		return info.(*xmlStructInfo)
+	}
+	info := &xmlStructInfo{}
+	var names []namedField
+	info.add(t, nil, &names)
+	// Fields hidden by others with the same name are left out
+	keep, _ := dominantFields(names)
+	fields := info.fields[:0]
+	for i, f := range info.fields {
+		if keep[i] {
+			fields = append(fields, f)
+		}
+	}
+	info.fields = fields
+	stored, _ := xmlStructInfos.LoadOrStore(t, info)
+	return stored.(*xmlStructInfo)
+}
+
+func (info *xmlStructInfo) add(t reflect.Type, index []int, names *[]namedField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		xmlTag := f.Tag.Get("xml")
+		if xmlTag == "-" {
+			continue
+		}
+		if f.Name == "XMLName" && f.Type == xmlNameType {
+			if index == nil {
+				info.name = parseXMLName(strings.Split(xmlTag, ",")[0])
+				info.nameIndex = fieldIndex
+			}
+			continue
+		}
+		jsonTag := strings.Split(f.Tag.Get("json"), ",")
+		if xmlTag == "" && jsonTag[0] == "-" {
+			continue
+		}
+
+		// Embedded structs are flattened, as the encoders do
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && xmlTag == "" && jsonTag[0] == "" && ft.Kind() == reflect.Struct && !opaque(f.Type) {
+			info.add(ft, fieldIndex, names)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		options := strings.Split(xmlTag, ",")
+		field := xmlField{index: fieldIndex, omitEmpty: contains(options[1:], "omitempty") || contains(jsonTag[1:], "omitempty")}
+		switch {
+		case contains(options[1:], "attr"):
+			field.kind = xmlFieldAttr
+		case contains(options[1:], "chardata"):
+			field.kind = xmlFieldCharData
+		case contains(options[1:], "innerxml"):
+			field.kind = xmlFieldInnerXML
+		case contains(options[1:], "comment"):
+			field.kind = xmlFieldComment
+		}
+
+		name := options[0]
+		if name == "" {
+			name = jsonTag[0]
+		}
+		tagged := name != ""
+		if name == "" {
+			name = f.Name
+		}
+		key := name
+		switch field.kind {
+		case xmlFieldAttr:
+			key = "attr " + name
+		case xmlFieldCharData, xmlFieldInnerXML, xmlFieldComment:
+			key = "," + options[1]
+		}
+		*names = append(*names, namedField{name: key, depth: len(fieldIndex), tagged: tagged})
+
+		if path := strings.Split(name, ">"); len(path) > 1 && field.kind == xmlFieldElement {
+			field.parents = path[:len(path)-1]
+			name = path[len(path)-1]
+		}
+		field.name = parseXMLName(name)
+		info.fields = append(info.fields, field)
+	}
+}
+
+// parseXMLName splits the "namespace name" form of xml tags
+func parseXMLName(tag string) xml.Name {
+	if i := strings.LastIndex(tag, " "); i >= 0 {
+		return xml.Name{Space: tag[:i], Local: tag[i+1:]}
+	}
+	return xml.Name{Local: tag}
+}
+
+func isXMLMarshaler(t reflect.Type) bool {
+	return t.Implements(xmlMarshalerType) || t.Implements(textMarshalerType)
+}
+
+// xmlMarshaler returns v as something encoding/xml can encode with its own
+// methods, with a pointer for pointer receivers
+func xmlMarshaler(v reflect.Value) (interface{}, bool) {
+	t := v.Type()
+	if isXMLMarshaler(t) {
+		return v.Interface(), true
+	}
+	if isXMLMarshaler(reflect.PtrTo(t)) {
+		ptr := reflect.New(t)
+		ptr.Elem().Set(v)
+		return ptr.Interface(), true
+	}
+	return nil, false
+}
+
+// xmlText formats a value for character data or an attribute
+func xmlText(v reflect.Value) (string, error) {
+	if m, ok := xmlMarshaler(v); ok {
+		if m, ok := m.(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			return string(text), err
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// base64 like JSON
+			bytes := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bytes), v)
+			return base64.StdEncoding.EncodeToString(bytes), nil
+		}
+	}
+	return "", fmt.Errorf("[xml] cannot encode %s as text", v.Type())
+}
+
+// xmlTypeName is the name of a type for the root element, "" for nil and
+// types without a name
+func xmlTypeName(t reflect.Type) string {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// validXMLName reports whether a map key can be used as an element name
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
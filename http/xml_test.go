@@ -0,0 +1,188 @@
+package http
+
+import (
+	"bytes"
+	"encoding/xml"
+	basehttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type xmlPatient struct {
+	ID       string    `json:"id" xml:"id,attr"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email,omitempty"`
+	City     string    `json:"city" xml:"address>city"`
+	Postcode string    `json:"postcode" xml:"address>postcode"`
+	Balance  xmlAmount `json:"balance"`
+	Hidden   string    `json:"-"`
+}
+
+type xmlAmount struct {
+	Currency string `xml:"currency,attr"`
+	Value    string `xml:",chardata"`
+}
+
+func TestEncodeXML(t *testing.T) {
+	patient := xmlPatient{ID: "p1", Name: "Ada", City: "London", Postcode: "SW1Y", Balance: xmlAmount{Currency: "GBP", Value: "12.50"}, Hidden: "secret"}
+
+	tests := []struct {
+		name     string
+		opts     XMLOptions
+		response interface{}
+		want     string
+	}{
+		{
+			name:     "struct",
+			opts:     XMLOptions{OmitDeclaration: true},
+			response: patient,
+			want:     `<xmlPatient id="p1"><name>Ada</name><address><city>London</city><postcode>SW1Y</postcode></address><balance currency="GBP">12.50</balance></xmlPatient>`,
+		},
+		{
+			name:     "map",
+			opts:     XMLOptions{OmitDeclaration: true},
+			response: map[string]interface{}{"b": 1, "a": "x", "1st": true, "nested": map[string]int{"n": 2}, "none": nil},
+			want:     `<response><entry key="1st">true</entry><a>x</a><b>1</b><nested><n>2</n></nested></response>`,
+		},
+		{
+			name:     "slice",
+			opts:     XMLOptions{OmitDeclaration: true},
+			response: []interface{}{1, "two"},
+			want:     `<response><item>1</item><item>two</item></response>`,
+		},
+		{
+			name:     "root and namespace",
+			opts:     XMLOptions{Root: "patient", Namespace: "urn:example:patients", OmitDeclaration: true},
+			response: map[string]string{"name": "Ada"},
+			want:     `<patient xmlns="urn:example:patients"><name>Ada</name></patient>`,
+		},
+		{
+			name:     "declaration",
+			response: nil,
+			want:     `<?xml version="1.0" encoding="UTF-8"?><response></response>`,
+		},
+		{
+			name:     "envelope meta",
+			opts:     XMLOptions{OmitDeclaration: true},
+			response: Envelope{Data: map[string]int{"n": 1}, Meta: EnvelopeMeta{"request_id": "abc", "paging": PageInfo{Limit: 10}}},
+			want:     `<response><data><n>1</n></data><meta><paging><limit>10</limit><offset>0</offset></paging><request_id>abc</request_id></meta></response>`,
+		},
+	}
+	defer SetXMLOptions(XMLOptions{})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetXMLOptions(test.opts)
+			var buf bytes.Buffer
+			if err := encodeXML(&buf, test.response, EncodeOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRespondXML(t *testing.T) {
+	SetXMLOptions(XMLOptions{OmitDeclaration: true})
+	defer SetXMLOptions(XMLOptions{})
+
+	serve := func(target string, handler basehttp.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Accept", "application/xml")
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+
+	t.Run("shaped types keep their name", func(t *testing.T) {
+		rec := serve("/?"+FieldsParam+"=name", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			Respond(w, r, basehttp.StatusOK, xmlPatient{ID: "p1", Name: "Ada"})
+		})
+		if got, want := rec.Body.String(), `<xmlPatient><name>Ada</name></xmlPatient>`; got != want {
+			t.Errorf("got  %s\nwant %s", got, want)
+		}
+	})
+
+	t.Run("unnamed types don't borrow a shaped type's name", func(t *testing.T) {
+		rec := serve("/", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			Respond(w, r, basehttp.StatusOK, struct {
+				Name string `json:"name"`
+			}{Name: "Ada"})
+		})
+		if got, want := rec.Body.String(), `<response><name>Ada</name></response>`; got != want {
+			t.Errorf("got  %s\nwant %s", got, want)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		rec := serve("/", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			RespondError(w, r, basehttp.StatusNotFound)
+		})
+		var got struct {
+			XMLName    xml.Name
+			StatusCode int    `xml:"status_code"`
+			Message    string `xml:"message"`
+		}
+		if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%v: %s", err, rec.Body)
+		}
+		if got.XMLName.Local != "ResponseError" || got.StatusCode != 404 || got.Message != "Not Found" {
+			t.Errorf("got %+v from %s", got, rec.Body)
+		}
+	})
+
+	t.Run("a failure aborts the response", func(t *testing.T) {
+		defer func() {
+			if _, ok := recover().(abortResponse); !ok {
+				t.Error("Respond didn't abort the response")
+			}
+		}()
+		serve("/", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			Respond(w, r, basehttp.StatusOK, []interface{}{"sent", make(chan int)})
+		})
+	})
+
+	t.Run("envelope meta", func(t *testing.T) {
+		type version struct {
+			APIVersion string `json:"api_version"`
+		}
+		RegisterEnvelopeMeta(func(r *basehttp.Request, meta EnvelopeMeta) {
+			meta["labels"] = map[string]string{"region": "eu", "tier": "gold"}
+			meta["version"] = version{APIVersion: "v2"}
+		})
+		defer func() {
+			envelopeMetaFuncs.Lock()
+			envelopeMetaFuncs.funcs = nil
+			envelopeMetaFuncs.Unlock()
+		}()
+		rec := serve("/?"+EnvelopeParam+"=true", func(w basehttp.ResponseWriter, r *basehttp.Request) {
+			Respond(w, r, basehttp.StatusOK, map[string]int{"n": 1})
+		})
+		for _, want := range []string{
+			`<data><n>1</n></data>`,
+			`<labels><region>eu</region><tier>gold</tier></labels>`,
+			`<version><api_version>v2</api_version></version>`,
+		} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("missing %s in %s", want, rec.Body)
+			}
+		}
+	})
+}
+
+func TestValidXMLName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"name": true, "_id": true, "a-b.c1": true,
+		"": false, "1st": false, "xmlns": false, "has space": false, "a:b": false,
+	} {
+		if got := validXMLName(name); got != want {
+			t.Errorf("validXMLName(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if got := xmlTypeName(reflect.TypeOf(&xmlPatient{})); got != "xmlPatient" {
+		t.Errorf("xmlTypeName(*xmlPatient) = %q, want xmlPatient", got)
+	}
+}
@@ -0,0 +1,58 @@
+// Package env reads configuration from environment variables, shared by the
+// grpc and http packages. Invalid values are logged and the default is kept.
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DocHQ/logging"
+)
+
+// Duration reads a time.ParseDuration value, e.g. 45s
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logging.Errorf("invalid duration for %s: %v", key, err)
+		return def
+	}
+	return d
+}
+
+// Int reads a non-negative integer
+func Int(key string, def int) int {
+	return IntRange(key, def, 0, int(^uint(0)>>1))
+}
+
+// IntRange reads an integer between min and max inclusive
+func IntRange(key string, def, min, max int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < min || i > max {
+		logging.Errorf("invalid value for %s: %q", key, v)
+		return def
+	}
+	return i
+}
+
+// Bool reads a strconv.ParseBool value, e.g. true or 1
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logging.Errorf("invalid value for %s: %v", key, err)
+		return def
+	}
+	return b
+}
@@ -0,0 +1,22 @@
+// Package metrics holds what the http and grpc packages share for their
+// Prometheus metrics
+package metrics
+
+import (
+	"github.com/DocHQ/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers c with the default registry. If an identical collector
+// is already there (e.g. a second Router in tests) that one is returned, so
+// callers always get the collector that is being scraped.
+func Register(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		logging.Errorf("unable to register metrics: %v", err)
+	}
+	return c
+}
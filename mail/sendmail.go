@@ -1,13 +1,18 @@
 package mail
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/DocHQ/helpers/tracing"
 	"github.com/DocHQ/logging/sentry"
 )
 
@@ -33,6 +38,27 @@ func init() {
 }
 
 func SendGridEmail(sendGridEmailTmpl string, fromEmail *mail.Email, receipients []*mail.Email, subject, body string, fileInfo []*FileInfo) error {
+	return SendGridEmailContext(context.Background(), sendGridEmailTmpl, fromEmail, receipients, subject, body, fileInfo)
+}
+
+// SendGridEmailContext is SendGridEmail recorded as a span under the trace in ctx
+func SendGridEmailContext(ctx context.Context, sendGridEmailTmpl string, fromEmail *mail.Email, receipients []*mail.Email, subject, body string, fileInfo []*FileInfo) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "SendGridEmail",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("sendgrid.template_id", sendGridEmailTmpl),
+			attribute.Int("sendgrid.recipients", len(receipients)),
+			attribute.Int("sendgrid.attachments", len(fileInfo)),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	var peopleToEmail []*mail.Email
 
 	for _, receipient := range receipients {
@@ -74,6 +100,8 @@ func SendGridEmail(sendGridEmailTmpl string, fromEmail *mail.Email, receipients
 		return fmt.Errorf("sendgridClient.Send: %s", err)
 	}
 
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+
 	if res.StatusCode != 202 {
 		return fmt.Errorf("sendgridClient.Send: incorrect status code returned: %v, %s", res.StatusCode, res.Body)
 	}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many calls MemoryStore lets through between removing
+// keys that have gone quiet
+const sweepEvery = 1000
+
+// MemoryStore keeps limits in process memory, each instance of a service
+// counts separately
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryEntry
+	calls   int
+}
+
+type memoryEntry struct {
+	bucket  tokenBucketState
+	window  slidingWindowState
+	expires int64
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*memoryEntry{}}
+}
+
+// Allow implements Store
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	ms := now.UnixNano() / int64(time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls%sweepEvery == 0 {
+		s.sweep(ms)
+	}
+
+	entry, exists := s.buckets[key]
+	if !exists {
+		entry = &memoryEntry{}
+		s.buckets[key] = entry
+	}
+
+	if limit.Algorithm == SlidingWindow {
+		allowed := countInWindow(&entry.window, exists, limit, ms)
+		entry.expires = entry.window.start + 2*limit.Period.Milliseconds()
+		return slidingWindowResult(allowed, entry.window.previous, entry.window.current, limit, ms), nil
+	}
+
+	allowed := takeToken(&entry.bucket, exists, limit, ms)
+	result := tokenBucketResult(allowed, entry.bucket.tokens, limit)
+	entry.expires = ms + result.Reset.Milliseconds()
+	return result, nil
+}
+
+// sweep drops the entries that would be back to a full allowance anyway
+func (s *MemoryStore) sweep(now int64) {
+	for key, entry := range s.buckets {
+		if entry.expires < now {
+			delete(s.buckets, key)
+		}
+	}
+}
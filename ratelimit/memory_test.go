@@ -0,0 +1,9 @@
+package ratelimit
+
+import (
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemoryStore() })
+}
@@ -0,0 +1,218 @@
+// Package ratelimit decides whether a caller may make another request. It is
+// shared by the rate limiting middleware in the http package and the
+// interceptors in the grpc package so both count the same way.
+//
+//	limiter := ratelimit.New(ratelimit.NewMemoryStore(), ratelimit.Limit{
+//		Requests: 100,
+//		Period:   time.Minute,
+//	})
+//	result, err := limiter.Allow(ctx, clientIP)
+//
+// Limits are kept in a Store. MemoryStore is enough for a single instance,
+// use RedisStore when the limit has to hold across every replica.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Algorithm chooses how requests are counted
+type Algorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit.Burst requests and then
+	// Limit.Requests per Limit.Period, refilled continuously
+	TokenBucket Algorithm = iota
+
+	// SlidingWindow allows Limit.Requests in any Limit.Period, estimated from
+	// the counts of the current and previous fixed windows
+	SlidingWindow
+)
+
+// Limit describes how many requests are allowed
+type Limit struct {
+	Requests  int
+	Period    time.Duration
+	Algorithm Algorithm
+
+	// Burst is the bucket size for TokenBucket, Requests if 0
+	Burst int
+}
+
+// Result is the outcome of a call to Allow, with what is needed to set the
+// RateLimit-* response headers
+type Result struct {
+	Allowed bool
+
+	// Limit is the number of requests the caller can make in one go
+	Limit int
+
+	// Remaining is how many more requests are allowed right now
+	Remaining int
+
+	// Reset is how long until the full Limit is available again
+	Reset time.Duration
+
+	// RetryAfter is how long to wait before trying again, only set when
+	// the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the state of the limits. Implementations must make each call
+// atomic for a key as they will be called concurrently.
+type Store interface {
+	// Allow counts a request against key and reports whether it is within limit
+	Allow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// ErrInvalidLimit is returned for a Limit without positive Requests or with a
+// Period under a millisecond, the resolution limits are counted in
+var ErrInvalidLimit = errors.New("ratelimit: Requests must be greater than zero and Period at least 1ms")
+
+// Limiter applies a Limit using a Store
+type Limiter struct {
+	Store Store
+	Limit Limit
+}
+
+// New returns a Limiter for limit backed by store
+func New(store Store, limit Limit) *Limiter {
+	return &Limiter{Store: store, Limit: limit}
+}
+
+// Allow counts a request for key
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	if l.Limit.Requests <= 0 || l.Limit.Period < time.Millisecond {
+		return Result{}, ErrInvalidLimit
+	}
+	return l.Store.Allow(ctx, key, l.Limit, time.Now())
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Implementation
+//
+// The arithmetic is shared by the stores so that MemoryStore and RedisStore
+// behave identically, RedisStore runs the same steps in Lua and then uses the
+// result functions here. Times are in milliseconds.
+
+func (l Limit) capacity() int {
+	if l.Algorithm == TokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// refillRate is the number of tokens added per millisecond
+func (l Limit) refillRate() float64 {
+	return float64(l.Requests) / float64(l.Period.Milliseconds())
+}
+
+type tokenBucketState struct {
+	tokens float64
+	last   int64
+}
+
+// takeToken refills the bucket for the time since it was last used and takes
+// a token from it if there is one
+func takeToken(state *tokenBucketState, exists bool, limit Limit, now int64) bool {
+	capacity := float64(limit.capacity())
+	if !exists {
+		state.tokens, state.last = capacity, now
+	}
+	if now > state.last {
+		state.tokens = math.Min(capacity, state.tokens+float64(now-state.last)*limit.refillRate())
+		state.last = now
+	}
+	if state.tokens >= 1 {
+		state.tokens--
+		return true
+	}
+	return false
+}
+
+func tokenBucketResult(allowed bool, tokens float64, limit Limit) Result {
+	rate := limit.refillRate()
+	capacity := limit.capacity()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     capacity,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     millis((float64(capacity) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = millis((1 - tokens) / rate)
+	}
+	return result
+}
+
+type slidingWindowState struct {
+	start    int64
+	previous int
+	current  int
+}
+
+// countInWindow moves the window along to now and counts the request if the
+// estimated number of requests in the last Period leaves room for it
+func countInWindow(state *slidingWindowState, exists bool, limit Limit, now int64) bool {
+	window := limit.Period.Milliseconds()
+	start := now - now%window
+	if !exists {
+		state.start = start
+	}
+	if state.start != start {
+		if state.start == start-window {
+			state.previous = state.current
+		} else {
+			state.previous = 0
+		}
+		state.current = 0
+		state.start = start
+	}
+
+	if slidingEstimate(state.previous, state.current, now-start, window)+1 <= float64(limit.Requests) {
+		state.current++
+		return true
+	}
+	return false
+}
+
+func slidingEstimate(previous, current int, elapsed, window int64) float64 {
+	return float64(previous)*float64(window-elapsed)/float64(window) + float64(current)
+}
+
+func slidingWindowResult(allowed bool, previous, current int, limit Limit, now int64) Result {
+	window := limit.Period.Milliseconds()
+	elapsed := now % window
+	estimate := slidingEstimate(previous, current, elapsed, window)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Max(0, math.Floor(float64(limit.Requests)-estimate))),
+		Reset:     time.Duration(window-elapsed) * time.Millisecond,
+	}
+	if !allowed {
+		if current+1 > limit.Requests || previous == 0 {
+			// Nothing changes until the next window starts
+			result.RetryAfter = result.Reset
+		} else {
+			// When enough of the previous window has slid out of view
+			// for one more request to fit
+			free := float64(window) - float64(limit.Requests-current-1)*float64(window)/float64(previous)
+			result.RetryAfter = millis(free - float64(elapsed))
+		}
+	}
+	return result
+}
+
+// millis converts a fractional number of milliseconds to a duration, rounding
+// up so callers told to wait are not too early
+func millis(ms float64) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(ms)) * time.Millisecond
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// start is at the beginning of a one second window so the sliding window
+// arithmetic is easy to follow
+var start = time.Unix(1600000000, 0)
+
+type step struct {
+	at         time.Duration
+	key        string
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+}
+
+func runSteps(t *testing.T, store Store, limit Limit, steps []step) {
+	t.Helper()
+	for i, s := range steps {
+		key := s.key
+		if key == "" {
+			key = "client"
+		}
+		result, err := store.Allow(context.Background(), key, limit, start.Add(s.at))
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if result.Allowed != s.allowed || result.Remaining != s.remaining || result.RetryAfter != s.retryAfter {
+			t.Errorf("step %d at %v: got allowed=%v remaining=%d retry=%v, want allowed=%v remaining=%d retry=%v",
+				i, s.at, result.Allowed, result.Remaining, result.RetryAfter, s.allowed, s.remaining, s.retryAfter)
+		}
+	}
+}
+
+// testStore runs the same requests against each Store, they must agree
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("TokenBucket", func(t *testing.T) {
+		limit := Limit{Requests: 2, Period: time.Second, Burst: 3}
+		runSteps(t, newStore(t), limit, []step{
+			{at: 0, allowed: true, remaining: 2},
+			{at: 0, allowed: true, remaining: 1},
+			{at: 0, allowed: true, remaining: 0},
+			{at: 0, allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond},
+			{at: 0, key: "other", allowed: true, remaining: 2},
+			{at: 500 * time.Millisecond, allowed: true, remaining: 0},
+			{at: 500 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond},
+			{at: 3 * time.Second, allowed: true, remaining: 2},
+		})
+	})
+
+	t.Run("SlidingWindow", func(t *testing.T) {
+		limit := Limit{Requests: 4, Period: time.Second, Algorithm: SlidingWindow}
+		runSteps(t, newStore(t), limit, []step{
+			{at: 0, allowed: true, remaining: 3},
+			{at: 0, allowed: true, remaining: 2},
+			{at: 0, allowed: true, remaining: 1},
+			{at: 0, allowed: true, remaining: 0},
+			{at: 0, allowed: false, remaining: 0, retryAfter: time.Second},
+			{at: 0, key: "other", allowed: true, remaining: 3},
+			// Half of the previous window's 4 requests still count
+			{at: 1500 * time.Millisecond, allowed: true, remaining: 1},
+			{at: 1500 * time.Millisecond, allowed: true, remaining: 0},
+			{at: 1500 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 250 * time.Millisecond},
+			// Two windows later nothing is left
+			{at: 3500 * time.Millisecond, allowed: true, remaining: 3},
+		})
+	})
+}
+
+func TestLimiterRejectsInvalidLimits(t *testing.T) {
+	for _, limit := range []Limit{
+		{Requests: 0, Period: time.Second},
+		{Requests: 10, Period: 0},
+		{Requests: 10, Period: 500 * time.Microsecond},
+	} {
+		_, err := New(NewMemoryStore(), limit).Allow(context.Background(), "client")
+		if err != ErrInvalidLimit {
+			t.Errorf("%+v: err = %v, want ErrInvalidLimit", limit, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore keeps limits in Redis so they are shared by every instance of a
+// service. Each key is a small hash that expires once it is no longer needed.
+type RedisStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedisStore returns a store using client, which can be a *redis.Client,
+// *redis.ClusterClient or *redis.Ring. Keys are prefixed with prefix so more
+// than one service can share a Redis.
+func NewRedisStore(client redis.Scripter, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Allow implements Store
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	ms := now.UnixNano() / int64(time.Millisecond)
+	keys := []string{s.prefix + key}
+
+	if limit.Algorithm == SlidingWindow {
+		values, err := slidingWindowScript.Run(ctx, s.client, keys,
+			limit.Period.Milliseconds(), limit.Requests, ms).Int64Slice()
+		if err != nil {
+			return Result{}, err
+		}
+		return slidingWindowResult(values[0] == 1, int(values[1]), int(values[2]), limit, ms), nil
+	}
+
+	values, err := tokenBucketScript.Run(ctx, s.client, keys,
+		strconv.FormatFloat(limit.refillRate(), 'g', -1, 64), limit.capacity(), ms).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, _ := values[0].(int64)
+	tokens, _ := values[1].(string)
+	remaining, err := strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return tokenBucketResult(allowed == 1, remaining, limit), nil
+}
+
+// These scripts are the Lua versions of takeToken and countInWindow.
+// Numbers returned to Redis are truncated to integers so the token count is
+// returned as a string.
+
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+	tokens = capacity
+	last = now
+end
+if now > last then
+	tokens = math.min(capacity, tokens + (now - last) * rate)
+	last = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 't', tostring(tokens), 'ts', last)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local start = now - (now % window)
+
+local state = redis.call('HMGET', KEYS[1], 's', 'p', 'c')
+local s = tonumber(state[1]) or start
+local previous = tonumber(state[2]) or 0
+local current = tonumber(state[3]) or 0
+if s ~= start then
+	if s == start - window then
+		previous = current
+	else
+		previous = 0
+	end
+	current = 0
+end
+
+local allowed = 0
+if previous * (window - (now - start)) / window + current + 1 <= limit then
+	current = current + 1
+	allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 's', start, 'p', previous, 'c', current)
+redis.call('PEXPIRE', KEYS[1], window * 2)
+return {allowed, previous, current}
+`)
@@ -0,0 +1,80 @@
+// Package requestid carries a correlation ID through a request so that logs
+// from every service it touches can be tied together. The http and grpc
+// packages both read and write it through this package so an ID received over
+// HTTP is forwarded on any gRPC calls made with the same context.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Header is the HTTP header the ID is read from and echoed back in
+const Header = "X-Request-ID"
+
+// MetadataKey is the gRPC metadata key the ID is carried in
+const MetadataKey = "x-request-id"
+
+// maxLength stops a client filling our logs with an enormous ID
+const maxLength = 128
+
+type contextKey struct{}
+
+// New generates a random ID, 32 hex characters so it has the same shape as a
+// W3C trace id
+func New() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand failing means the system is in a very bad way, an ID
+		// that isn't unique is the least of our problems
+		return "00000000000000000000000000000000"
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID carried by ctx, or "" if there isn't one
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromTraceparent returns the trace id part of a W3C traceparent header
+// (version-traceid-parentid-flags), or "" if it isn't valid
+func FromTraceparent(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || !isHex(parts[1]) || parts[1] == "00000000000000000000000000000000" {
+		return ""
+	}
+	return parts[1]
+}
+
+// Valid reports whether an ID received from a client is safe to use, i.e. not
+// too long and only printable ASCII without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
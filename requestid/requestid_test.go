@@ -0,0 +1,67 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	a, b := New(), New()
+	if len(a) != 32 || !isHex(a) {
+		t.Errorf("New() = %q, want 32 hex characters", a)
+	}
+	if a == b {
+		t.Error("New() returned the same id twice")
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"has space", false},
+		{"new\nline", false},
+		{"café", false},
+		{strings.Repeat("a", maxLength), true},
+		{strings.Repeat("a", maxLength+1), false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestFromTraceparent(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		want        string
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736", ""},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", ""},
+	}
+	for _, tt := range tests {
+		if got := FromTraceparent(tt.traceparent); got != tt.want {
+			t.Errorf("FromTraceparent(%q) = %q, want %q", tt.traceparent, got, tt.want)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != "" {
+		t.Errorf("FromContext(empty) = %q", got)
+	}
+	if got := FromContext(NewContext(context.Background(), "abc")); got != "abc" {
+		t.Errorf("FromContext() = %q, want abc", got)
+	}
+}
@@ -0,0 +1,145 @@
+// Package tracing sets up OpenTelemetry for a service. Call Init once from
+// main, the http, grpc and mail packages create their spans through the global
+// tracer provider it installs and propagate W3C trace context between services.
+//
+//	provider, err := tracing.Init(ctx, tracing.ConfigFromEnv())
+//	if err != nil {
+//		logging.Fatal(err)
+//	}
+//	defer provider.Shutdown(context.Background())
+//
+// Until Init is called every span is a no-op, so nothing needs to change for
+// services that don't want tracing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// InstrumentationName is the name every span created by this module is
+// recorded under
+const InstrumentationName = "github.com/DocHQ/helpers"
+
+// Exporters understood by Config.Exporter, the names match the values of the
+// standard OTEL_TRACES_EXPORTER variable
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Propagator is the W3C trace context (and baggage) propagator used by the
+// middleware and interceptors, whether or not Init has been called
+var Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Config controls where spans are sent
+type Config struct {
+	// ServiceName is recorded on every span, OTEL_SERVICE_NAME
+	ServiceName string
+
+	// Exporter is one of the Exporter constants, OTEL_TRACES_EXPORTER.
+	// The OTLP endpoint, headers etc. are read by the exporter itself from
+	// the standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter string
+
+	// OTLPProtocol is "grpc" or "http/protobuf", OTEL_EXPORTER_OTLP_PROTOCOL
+	OTLPProtocol string
+
+	// SampleRatio is the fraction of new traces to record, between 0 and 1,
+	// OTEL_TRACES_SAMPLER_ARG. Traces started by another service follow the
+	// caller's decision.
+	SampleRatio float64
+}
+
+// ConfigFromEnv reads the config from the standard OpenTelemetry variables,
+// defaulting to the OTLP exporter over HTTP and sampling everything
+func ConfigFromEnv() Config {
+	c := Config{
+		ServiceName:  os.Getenv("OTEL_SERVICE_NAME"),
+		Exporter:     os.Getenv("OTEL_TRACES_EXPORTER"),
+		OTLPProtocol: os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"),
+		SampleRatio:  1,
+	}
+	if c.Exporter == "" {
+		c.Exporter = ExporterOTLP
+	}
+	if v := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); v != "" {
+		if ratio, err := strconv.ParseFloat(v, 64); err == nil {
+			c.SampleRatio = ratio
+		}
+	}
+	return c
+}
+
+// Provider is the tracer provider installed by Init. Tests can record spans
+// with the tracingtest package instead.
+type Provider struct {
+	*sdktrace.TracerProvider
+}
+
+// Init creates the tracer provider described by config and installs it, along
+// with Propagator, as the OpenTelemetry globals.
+// Call Shutdown on the result before exiting so buffered spans are flushed.
+func Init(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{}
+	var opts []sdktrace.TracerProviderOption
+
+	switch config.Exporter {
+	case ExporterNone:
+	case ExporterStdout:
+		exp, err := stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exp))
+	case ExporterOTLP, "":
+		var client otlptrace.Client
+		if config.OTLPProtocol == "grpc" {
+			client = otlptracegrpc.NewClient()
+		} else {
+			client = otlptracehttp.NewClient()
+		}
+		exp, err := otlptrace.New(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+	}
+
+	if config.ServiceName != "" {
+		opts = append(opts, sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(config.ServiceName),
+		)))
+	}
+	opts = append(opts, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))))
+
+	p.TracerProvider = sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(p.TracerProvider)
+	otel.SetTextMapPropagator(Propagator)
+
+	return p, nil
+}
+
+// Tracer returns the tracer used for the spans created by this module
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
@@ -0,0 +1,49 @@
+// Package tracingtest records spans in memory, so tests can check what the
+// http, grpc and mail packages traced without running a collector.
+//
+//	recorder := tracingtest.Init()
+//	defer recorder.Shutdown(context.Background())
+//	...
+//	spans := recorder.Spans()
+//
+// It is kept apart from the tracing package so services don't build the
+// test exporter into their binaries.
+package tracingtest
+
+import (
+	"github.com/DocHQ/helpers/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Recorder is a tracer provider that keeps every span in memory
+type Recorder struct {
+	*sdktrace.TracerProvider
+
+	exporter *tracetest.InMemoryExporter
+}
+
+// Init installs a Recorder that samples everything, along with
+// tracing.Propagator, as the OpenTelemetry globals in place of tracing.Init
+func Init() *Recorder {
+	r := &Recorder{exporter: tracetest.NewInMemoryExporter()}
+	r.TracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(r.exporter),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	otel.SetTracerProvider(r.TracerProvider)
+	otel.SetTextMapPropagator(tracing.Propagator)
+	return r
+}
+
+// Spans returns the spans ended so far
+func (r *Recorder) Spans() tracetest.SpanStubs {
+	return r.exporter.GetSpans()
+}
+
+// Reset forgets the spans recorded so far
+func (r *Recorder) Reset() {
+	r.exporter.Reset()
+}